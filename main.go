@@ -1,7 +1,6 @@
 package main
 
 import (
-	"container/ring"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -20,18 +19,27 @@ import (
 	"github.com/iotaledger/iota.go/pow"
 	. "github.com/iotaledger/iota.go/trinary"
 	"github.com/labstack/echo"
+	"github.com/luca-moser/confbox/measurement"
+	"github.com/luca-moser/confbox/metrics"
 	"github.com/luca-moser/confbox/models"
 	"github.com/luca-moser/confbox/quorum"
+	"github.com/luca-moser/confbox/store"
+	"github.com/luca-moser/confbox/stream"
+	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
-	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const configFile = "config.json"
 
 var logger *log.Logger
+var startTime = time.Now()
 
 func main() {
 	var acc account.Account
@@ -43,24 +51,60 @@ func main() {
 		logger = logger.WithDebug()
 	}
 
+	initProbe(&conf.Probe)
+	if conf.Health.MaxSendAge == 0 {
+		conf.Health.MaxSendAge = 3 * conf.Probe.PointInterval
+	}
+	measurer, err := measurement.New(measurement.Settings{
+		BatchSize:     batchSize,
+		PointInterval: pointInterval,
+		Windows:       conf.Windows,
+		Confidence:    conf.Confidence,
+	})
+	must(err)
+
 	// compose API
+	reportLog := quorum.NewReportLog(conf.Quorum.ReportLogSize)
 	httpClient := &http.Client{Timeout: time.Duration(conf.Quorum.Timeout) * time.Second}
 	apiSettings := quorum.QuorumHTTPClientSettings{
-		PrimaryNode:                &conf.Quorum.PrimaryNode,
+		FailoverNodes:              conf.Quorum.FailoverNodes,
+		Selection:                  quorum.SelectionStrategy(conf.Quorum.Selection),
 		Threshold:                  conf.Quorum.Threshold,
 		NoResponseTolerance:        conf.Quorum.NoResponseTolerance,
 		Client:                     httpClient,
+		Transport:                  nodeTransport(conf, httpClient),
 		Nodes:                      conf.Quorum.Nodes,
+		NodeWeights:                conf.Quorum.NodeWeights,
+		Policies:                   commandPolicies(conf),
 		MaxSubtangleMilestoneDelta: conf.Quorum.MaxSubtangleMilestoneDelta,
+		CircuitBreaker:             circuitBreakerSettings(conf),
+		ExcludeLaggingNodes:        conf.Quorum.ExcludeLaggingNodes,
+		MinSyncedNodes:             conf.Quorum.MinSyncedNodes,
+		QuarantineDuration:         time.Duration(conf.Quorum.QuarantineDuration) * time.Second,
+		FailoverBackoff:            time.Duration(conf.Quorum.FailoverBackoff) * time.Second,
+		PerElementQuorum:           conf.Quorum.PerElementQuorum,
+		ReportHook:                 reportLog.Add,
+		Defaults: &quorum.QuorumDefaults{
+			GetInclusionStates:     conf.Quorum.Defaults.GetInclusionStates,
+			WereAddressesSpentFrom: conf.Quorum.Defaults.WereAddressesSpentFrom,
+		},
 		ForceQuorumSend: map[api.IRICommand]struct{}{
 			api.BroadcastTransactionsCmd: {},
 		},
 	}
+	if conf.Quorum.PrimaryNode != "" {
+		apiSettings.PrimaryNode = &conf.Quorum.PrimaryNode
+	}
 	if conf.LocalPow {
 		_, powFunc := pow.GetFastestProofOfWorkImpl()
 		apiSettings.LocalProofOfWorkFunc = powFunc
 	}
-	iotaAPI, err := api.ComposeAPI(apiSettings, quorum.NewQuorumHTTPClient)
+	provider, err := quorum.NewQuorumHTTPClient(apiSettings)
+	must(err)
+	quorumProvider := provider.(quorum.QuorumProvider)
+	iotaAPI, err := api.ComposeAPI(apiSettings, func(settings interface{}) (api.Provider, error) {
+		return provider, nil
+	})
 	must(err)
 
 	// init account
@@ -95,36 +139,61 @@ func main() {
 	must(acc.Start())
 	defer acc.Shutdown()
 
-	getResult := make(chan struct{})
-	backResult := make(chan models.ConfRate)
-	go measure(em, getResult, backResult)
+	// restore the measurement data of previous runs
+	var st *store.Store
+	restoredConfirmed := make(chan Hash)
+	if conf.StorePath != "" {
+		st, err = store.New(conf.StorePath)
+		must(err)
+		defer st.Close()
+		unconfirmed, err := restore(measurer, st)
+		must(err)
+		go trackRestored(iotaAPI, unconfirmed, measurer.Retention(), time.Duration(conf.TransferPolling.Interval)*time.Second, restoredConfirmed)
+	}
+
+	// the windows which must be filled for the ConfBox to be ready, defaults to the smallest window
+	readyWindows := conf.Health.ReadyWindows
+	if len(readyWindows) == 0 {
+		readyWindows = measurer.Windows()[:1]
+	}
+
+	broker := stream.NewBroker()
+	go measure(em, measurer, st, restoredConfirmed, broker, conf.ExposedConfig)
 
-	// send off a bundle each minute
+	// send off a batch each point interval
 	addr := randAddr()
 	logger.Infof("will use %s as destination address", addr)
 	var counter int
 	go func() {
-		ticker := time.NewTicker(time.Duration(1) * time.Minute)
+		ticker := time.NewTicker(pointInterval)
 		for {
 			msg, _ := converter.ASCIIToTrytes(fmt.Sprintf("conf box tx: %d", counter))
 			retries := 0
-			for i := 0; i < txPerPoint; i++ {
+			sent := 0
+			for i := 0; i < batchSize; i++ {
 				_, err := acc.Send(account.Recipient{Address: addr, Tag: "CONFBOX", Message: msg})
 				if err != nil {
 					logger.Errorf("unable to send transaction: %s", err.Error())
+					atomic.AddUint64(&sendFailures, 1)
+					lastSendError.Store(err.Error())
 					if retries != maxRetries {
 						i--
 						retries++
+						atomic.AddUint64(&sendRetries, 1)
 					} else {
 						logger.Errorf("couldn't send transaction at pos %d of batch after %d retries", i+1, maxRetries)
+						atomic.AddUint64(&sendsSkipped, 1)
 						retries = 0
 					}
 					continue
 				}
 				retries = 0
 				counter++
+				sent++
+				atomic.StoreInt64(&lastSendSuccess, time.Now().UnixNano())
+				lastSendError.Store("")
 			}
-			logger.Debugf("sent off %d txs", txPerPoint)
+			logger.Debugf("sent off %d of %d txs", sent, batchSize)
 			<-ticker.C
 		}
 	}()
@@ -133,9 +202,12 @@ func main() {
 	go func() {
 		ticker := time.NewTicker(time.Duration(conf.ResultLogInterval) * time.Minute)
 		for {
-			getResult <- struct{}{}
-			result := <-backResult
-			logger.Infof("5: %.2f, 10: %.2f, 15: %.2f, 30: %.2f (points: %d)", result.Avg5, result.Avg10, result.Avg15, result.Avg30, pointsFilled)
+			result := measurer.Rates()
+			rates := make([]string, len(result.Windows))
+			for i, w := range result.Windows {
+				rates[i] = fmt.Sprintf("%d: %.2f (p50 %.0fs)", w.Window, w.Rate, w.Latency.P50)
+			}
+			logger.Infof("%s (points: %d)", strings.Join(rates, ", "), measurer.PointsFilled())
 			<-ticker.C
 		}
 	}()
@@ -143,122 +215,614 @@ func main() {
 	e := echo.New()
 	e.HideBanner = true
 	e.GET("/", func(c echo.Context) error {
-		getResult <- struct{}{}
-		result := <-backResult
-		res := models.Response{Config: conf.ExposedConfig, Results: result}
+		res := models.Response{Config: conf.ExposedConfig, Results: measurer.Rates()}
 		return c.JSON(http.StatusOK, res)
 	})
+	e.GET("/metrics", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, metrics.ContentType)
+		c.Response().WriteHeader(http.StatusOK)
+		return writeMetrics(c.Response(), measurer, quorumProvider.Stats())
+	})
+	e.GET("/history", func(c echo.Context) error {
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, models.HistoryResponse{Points: measurer.History(q)})
+	})
+	e.GET("/healthz", func(c echo.Context) error {
+		health, healthy := checkHealth(conf, measurer, quorumProvider.Stats())
+		if !healthy {
+			return c.JSON(http.StatusServiceUnavailable, health)
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+	e.GET("/readyz", func(c echo.Context) error {
+		health, healthy := checkHealth(conf, measurer, quorumProvider.Stats())
+		if !healthy || !windowsReady(health, readyWindows) {
+			return c.JSON(http.StatusServiceUnavailable, health)
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+	e.GET("/stream", func(c echo.Context) error {
+		return streamEvents(c, broker)
+	})
+	e.GET("/quorum/nodes", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, nodesResponse(quorumProvider.NodeHealth()))
+	})
+	e.GET("/quorum/reports", func(c echo.Context) error {
+		var limit int
+		if param := c.QueryParam("limit"); param != "" {
+			var err error
+			if limit, err = strconv.Atoi(param); err != nil || limit < 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+			}
+		}
+		return c.JSON(http.StatusOK, reportsResponse(reportLog.Reports(limit)))
+	})
 	must(e.Start(conf.Listen))
 }
 
-const txPerPoint = 5
-const retentionPolicy = 31
-const maxRetries = 5
+// creates the transport used to call the quorum nodes with the configured headers.
+func nodeTransport(conf *config, client *http.Client) *quorum.HTTPTransport {
+	transport := &quorum.HTTPTransport{Client: client, Headers: http.Header{}, NodeHeaders: map[string]http.Header{}}
+	for name, value := range conf.Quorum.Headers {
+		transport.Headers.Set(name, value)
+	}
+	for node, headers := range conf.Quorum.NodeHeaders {
+		transport.NodeHeaders[node] = http.Header{}
+		for name, value := range headers {
+			transport.NodeHeaders[node].Set(name, value)
+		}
+	}
+	return transport
+}
 
-var sizes = [4]int{5, 5, 5, 15}
+// returns the configured quorum policies keyed by command.
+func commandPolicies(conf *config) map[api.IRICommand]quorum.CommandPolicy {
+	policies := make(map[api.IRICommand]quorum.CommandPolicy, len(conf.Quorum.Policies))
+	for command, policy := range conf.Quorum.Policies {
+		policies[api.IRICommand(command)] = quorum.CommandPolicy{
+			Threshold:           policy.Threshold,
+			NoResponseTolerance: policy.NoResponseTolerance,
+			AcceptAny:           policy.AcceptAny,
+			MinAccepted:         policy.MinAccepted,
+			MinAcceptedShare:    policy.MinAcceptedShare,
+		}
+	}
+	return policies
+}
 
-var points = ring.New(retentionPolicy)
-var pointsFilled = 0
+// returns the circuit breaker settings of the quorum or nil if the circuit breaker is disabled.
+func circuitBreakerSettings(conf *config) *quorum.CircuitBreakerSettings {
+	if !conf.Quorum.CircuitBreaker.Enabled {
+		return nil
+	}
+	return &quorum.CircuitBreakerSettings{
+		FailureThreshold: conf.Quorum.CircuitBreaker.FailureThreshold,
+		OpenDuration:     time.Duration(conf.Quorum.CircuitBreaker.OpenDuration) * time.Second,
+	}
+}
 
-type bucket struct {
-	ok        bool
-	size      float64
-	confirmed float64
+// converts the health of the quorum nodes into its API representation.
+func nodesResponse(health []quorum.NodeHealth) models.NodesResponse {
+	res := models.NodesResponse{Nodes: make([]models.NodeHealth, len(health))}
+	for i, h := range health {
+		node := models.NodeHealth{
+			URL:                     h.URL,
+			State:                   string(h.State),
+			Successes:               h.Successes,
+			Failures:                h.Failures,
+			ConsecutiveFailures:     h.ConsecutiveFailures,
+			LastLatency:             float64(h.LastLatency) / float64(time.Millisecond),
+			AvgLatency:              float64(h.AvgLatency) / float64(time.Millisecond),
+			InFlight:                h.InFlight,
+			Lagging:                 h.Lagging,
+			SubtangleMilestoneIndex: h.SubtangleMilestoneIndex,
+			Errors:                  make([]models.NodeError, len(h.Errors)),
+		}
+		if !h.LastSuccess.IsZero() {
+			lastSuccess := h.LastSuccess
+			node.LastSuccess = &lastSuccess
+		}
+		if !h.OpenedAt.IsZero() {
+			openedAt := h.OpenedAt
+			node.OpenedAt = &openedAt
+		}
+		if !h.QuarantinedUntil.IsZero() {
+			quarantinedUntil := h.QuarantinedUntil
+			node.QuarantinedUntil = &quarantinedUntil
+		}
+		for j, nodeErr := range h.Errors {
+			node.Errors[j] = models.NodeError{Time: nodeErr.Time, Error: nodeErr.Error}
+		}
+		res.Nodes[i] = node
+	}
+	return res
 }
 
-func (b *bucket) rate() float64 {
-	if !b.ok {
-		return -1
+// converts the reports of quorum calls into their API representation.
+func reportsResponse(reports []quorum.QuorumReport) models.QuorumReportsResponse {
+	res := models.QuorumReportsResponse{Reports: make([]models.QuorumReport, len(reports))}
+	for i := range reports {
+		r := &reports[i]
+		report := models.QuorumReport{
+			Command:          string(r.Command),
+			Time:             r.Time,
+			Duration:         float64(r.Duration) / float64(time.Millisecond),
+			Percentage:       r.Percentage,
+			Selected:         r.Selected,
+			EarlyTermination: r.EarlyTermination,
+			ElementQuorum:    r.ElementQuorum,
+			DefaultsInjected: r.DefaultsInjected,
+			WriteQuorum:      r.WriteQuorum,
+			Agreed:           r.Agreed(),
+			Dissented:        r.Dissented(),
+			Rejected:         r.Rejected(),
+			Pending:          r.Pending(),
+			Groups:           make([]models.VoteGroup, len(r.Groups)),
+			Nodes:            make([]models.NodeOutcome, len(r.Nodes)),
+		}
+		if r.Err != nil {
+			report.Error = r.Err.Error()
+		}
+		for j, group := range r.Groups {
+			report.Groups[j] = models.VoteGroup{Votes: group.Votes, StatusCode: group.StatusCode, Nodes: group.Nodes}
+		}
+		for j, node := range r.Nodes {
+			report.Nodes[j] = models.NodeOutcome{
+				URL:        node.URL,
+				StatusCode: node.StatusCode,
+				Latency:    float64(node.Latency) / float64(time.Millisecond),
+				Group:      node.Group,
+				Pending:    node.Pending,
+			}
+			if node.Err != nil {
+				report.Nodes[j].Error = node.Err.Error()
+			}
+		}
+		res.Reports[i] = report
 	}
-	return math.Floor((b.confirmed/b.size)*100) / 100
+	return res
 }
 
-func measure(em event.EventMachine, getResult chan struct{}, backResult chan models.ConfRate) {
-	lis := listener.NewChannelEventListener(em).RegConfirmedTransfers().RegSentTransfers()
+// checks whether the sender recently sent off a transaction successfully and whether the
+// quorum reaches its nodes and stays within the max subtangle milestone delta.
+func checkHealth(conf *config, measurer *measurement.Measurer, quorumStats quorum.QuorumStats) (models.HealthResponse, bool) {
+	health := models.HealthResponse{Status: models.HealthStatusOk}
+
+	// the sender is healthy if it sent off a transaction within the max send age
+	// or if it didn't have the chance to yet since the start
+	maxSendAge := time.Duration(conf.Health.MaxSendAge) * time.Second
+	health.Sender.Ok = time.Since(startTime) < maxSendAge
+	if last := atomic.LoadInt64(&lastSendSuccess); last != 0 {
+		lastSuccess := time.Unix(0, last)
+		health.Sender.LastSuccess = &lastSuccess
+		health.Sender.Ok = time.Since(lastSuccess) < maxSendAge
+	}
+	if lastErr, ok := lastSendError.Load().(string); ok {
+		health.Sender.Error = lastErr
+	}
+
+	// nodes are deemed unreachable if the last quorum call exceeded the no-response tolerance
+	health.Quorum.Ok = true
+	health.Quorum.SubtangleMilestoneDelta = quorumStats.SubtangleMilestoneDelta
+	health.Quorum.MaxSubtangleMilestoneDelta = conf.Quorum.MaxSubtangleMilestoneDelta
+	health.Quorum.LaggingNodes = quorumStats.LaggingNodes
+	if !quorumStats.LastCall.IsZero() {
+		lastCall := quorumStats.LastCall
+		health.Quorum.LastCall = &lastCall
+	}
+	if !quorumStats.LastSubtangleQuery.IsZero() {
+		lastQuery := quorumStats.LastSubtangleQuery
+		health.Quorum.LastSubtangleQuery = &lastQuery
+	}
+	if err := quorumStats.LastCallError; err != nil && errors.Cause(err) == quorum.ErrExceededNoResponseTolerance {
+		health.Quorum.Ok = false
+		health.Quorum.Error = err.Error()
+	}
+	if err := quorumStats.LastSubtangleQueryError; err != nil {
+		health.Quorum.Ok = false
+		health.Quorum.Error = err.Error()
+	}
+
+	for _, window := range measurer.Rates().Windows {
+		health.Windows = append(health.Windows, models.WindowHealth{Window: window.Window, Filled: window.Rate != -1})
+	}
+
+	healthy := health.Sender.Ok && health.Quorum.Ok
+	if !healthy {
+		health.Status = models.HealthStatusUnavailable
+	}
+	return health, healthy
+}
+
+// checks whether all of the given windows are filled.
+func windowsReady(health models.HealthResponse, required []int) bool {
+	filled := map[int]bool{}
+	for _, window := range health.Windows {
+		filled[window.Window] = window.Filled
+	}
+	for _, window := range required {
+		if !filled[window] {
+			return false
+		}
+	}
+	return true
+}
 
-	gathered := 0
+// interval in which a comment is sent to keep idle event streams open.
+const streamKeepAliveInterval = 15 * time.Second
+
+// streams the events of the broker as server-sent events. the optional comma separated
+// events query parameter defines the event types to subscribe to.
+func streamEvents(c echo.Context, broker *stream.Broker) error {
+	types := []stream.EventType{}
+	if param := c.QueryParam("events"); param != "" {
+		for _, name := range strings.Split(param, ",") {
+			t, err := stream.ParseEventType(strings.TrimSpace(name))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			types = append(types, t)
+		}
+	}
 
+	sub := broker.Subscribe(types...)
+	defer broker.Unsubscribe(sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
 	for {
 		select {
-		case e := <-lis.SentTransfer:
-			logger.Debugf("got sent transfer event %s", e[0].Hash)
-			m, ok := points.Value.(map[Hash]bool)
-			// either never used or we have looped in the ring buffer
-			if !ok || points.Value == nil || (len(m) > 0 && gathered == 0) {
-				m = map[Hash]bool{}
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := io.WriteString(res, ": keep-alive\n\n"); err != nil {
+				return nil
 			}
-			m[e[0].Hash] = false
-			points.Value = m
-			gathered++
-			// gathered all tx for this minute, lets forward to the next
-			if gathered == txPerPoint {
-				pointsFilled++
-				logger.Debugf("filled point with %d txs (points filled: %d)", txPerPoint, pointsFilled)
-				gathered = 0
-				points = points.Next()
+		case ev := <-sub.C:
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				return err
 			}
-		case e := <-lis.TransferConfirmed:
-			logger.Debugf("got transfer confirmed event %s", e[0].Hash)
-			hash := e[0].Hash
-			// traverse the ring buffer and set the confirmed flag accordingly
-			r := points
-			for i := 0; i < retentionPolicy; i++ {
-				m, ok := r.Value.(map[Hash]bool)
-				if !ok || m == nil {
-					r = r.Prev()
-					continue
-				}
-				if _, has := m[hash]; has {
-					logger.Debugf("set tx to be confirmed")
-					m[hash] = true
-					break
-				}
-				r = r.Prev()
-			}
-		case <-getResult:
-			r := points.Prev()
-
-			computeBucket := func(size int, b bucket) bucket {
-				for i := 0; i < size; i++ {
-					m, ok := r.Value.(map[Hash]bool)
-					if !ok {
-						return b
-					}
-					for _, v := range m {
-						b.size++
-						if v {
-							b.confirmed++
-						}
-					}
-					r = r.Prev()
-				}
-				b.ok = true
-				return b
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return nil
 			}
+		}
+		res.Flush()
+	}
+}
 
-			buckets := make([]bucket, len(sizes))
-			for i, size := range sizes {
-				buckets[i] = computeBucket(size, buckets[i])
-				if !buckets[i].ok {
-					break
-				}
-				if i != len(sizes)-1 {
-					cpy := buckets[i]
-					cpy.ok = false
-					buckets[i+1] = cpy
-				}
+// writes the measurement, sender and quorum metrics in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, measurer *measurement.Measurer, quorumStats quorum.QuorumStats) error {
+	mw := metrics.NewWriter(w)
+	result := measurer.Rates()
+
+	// windows which aren't filled yet or have nothing confirmed are left out
+	// instead of exporting their -1 placeholder as a sample
+	mw.Family("confbox_confirmation_rate", "Avg. confirmation rate per filled window.", metrics.KindGauge)
+	for _, window := range result.Windows {
+		if window.Rate < 0 {
+			continue
+		}
+		mw.Sample("confbox_confirmation_rate", window.Rate, metrics.Label{Name: "window", Value: strconv.Itoa(window.Window)})
+	}
+	mw.Family("confbox_confirmation_latency_seconds", "Confirmation latency percentiles per filled window.", metrics.KindGauge)
+	for _, window := range result.Windows {
+		if window.Latency.Count == 0 {
+			continue
+		}
+		windowLabel := metrics.Label{Name: "window", Value: strconv.Itoa(window.Window)}
+		mw.Sample("confbox_confirmation_latency_seconds", window.Latency.P50, windowLabel, metrics.Label{Name: "percentile", Value: "50"})
+		mw.Sample("confbox_confirmation_latency_seconds", window.Latency.P90, windowLabel, metrics.Label{Name: "percentile", Value: "90"})
+		mw.Sample("confbox_confirmation_latency_seconds", window.Latency.P99, windowLabel, metrics.Label{Name: "percentile", Value: "99"})
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"confbox_points_filled_total", "Points filled since the start.", uint64(measurer.PointsFilled())},
+		{"confbox_sent_transactions_total", "Probe transactions sent off.", atomic.LoadUint64(&sentTotal)},
+		{"confbox_confirmed_transactions_total", "Probe transactions which got confirmed.", atomic.LoadUint64(&confirmedTotal)},
+		{"confbox_send_failures_total", "Failed attempts to send off a probe transaction.", atomic.LoadUint64(&sendFailures)},
+		{"confbox_send_retries_total", "Retries of sending off a probe transaction.", atomic.LoadUint64(&sendRetries)},
+		{"confbox_send_skipped_total", "Probe transactions skipped after exhausting all retries.", atomic.LoadUint64(&sendsSkipped)},
+		{"confbox_quorum_calls_total", "IRI API calls executed in quorum.", quorumStats.Calls},
+		{"confbox_quorum_votes_total", "Node responses which voted in a quorum.", quorumStats.Votes},
+		{"confbox_quorum_failures_total", "Nodes which failed to give a response in a quorum call.", quorumStats.Failures},
+		{"confbox_quorum_no_response_tolerance_violations_total", "Quorum calls which exceeded the no-response tolerance.", quorumStats.NoResponseToleranceViolations},
+		{"confbox_quorum_not_reached_total", "Quorum calls which didn't reach the threshold.", quorumStats.QuorumsNotReached},
+		{"confbox_quorum_defaults_injected_total", "Quorum calls for which the defaults were injected.", quorumStats.DefaultsInjected},
+		{"confbox_quorum_element_quorums_total", "Quorum calls which were decided per element.", quorumStats.ElementQuorums},
+		{"confbox_quorum_element_defaults_injected_total", "Elements for which the defaults were injected.", quorumStats.ElementDefaultsInjected},
+		{"confbox_quorum_failovers_total", "Non-quorum calls which failed over to the next node.", quorumStats.Failovers},
+		{"confbox_quorum_early_terminations_total", "Quorum calls which returned before all nodes responded.", quorumStats.EarlyTerminations},
+		{"confbox_quorum_writes_not_accepted_total", "Writes which weren't accepted by enough nodes.", quorumStats.WritesNotAccepted},
+		{"confbox_quorum_lagging_nodes_excluded_total", "Nodes excluded from subtangle milestone queries as they lagged behind.", quorumStats.LaggingNodesExcluded},
+		{"confbox_quorum_forks_detected_total", "Subtangle milestone queries in which nodes reported different milestones for the same index.", quorumStats.ForksDetected},
+		{"confbox_quorum_subtangle_milestone_delta_violations_total", "Subtangle milestone queries which exceeded the max. delta.", quorumStats.SubtangleMilestoneDeltaViolations},
+	}
+	for _, counter := range counters {
+		mw.Family(counter.name, counter.help, metrics.KindCounter)
+		mw.Sample(counter.name, float64(counter.value))
+	}
+
+	mw.Family("confbox_quorum_subtangle_milestone_delta", "Delta between the highest and lowest latest solid subtangle milestone of the last query, including lagging nodes.", metrics.KindGauge)
+	mw.Sample("confbox_quorum_subtangle_milestone_delta", float64(quorumStats.SubtangleMilestoneDelta))
+	mw.Family("confbox_quorum_lagging_nodes", "Nodes excluded from the last subtangle milestone query as they lagged behind.", metrics.KindGauge)
+	mw.Sample("confbox_quorum_lagging_nodes", float64(quorumStats.LaggingNodes))
+	return mw.Flush()
+}
+
+// parses the from/to/step/hashes query parameters of a history request.
+// from and to are either unix timestamps or RFC3339 dates, step is either
+// a duration (i.e. 5m) or a number of seconds.
+func parseHistoryQuery(c echo.Context) (measurement.HistoryQuery, error) {
+	q := measurement.HistoryQuery{}
+	var err error
+	if q.From, err = parseQueryTime(c.QueryParam("from")); err != nil {
+		return q, fmt.Errorf("invalid from parameter: %s", err.Error())
+	}
+	if q.To, err = parseQueryTime(c.QueryParam("to")); err != nil {
+		return q, fmt.Errorf("invalid to parameter: %s", err.Error())
+	}
+	if step := c.QueryParam("step"); step != "" {
+		if secs, err := strconv.ParseUint(step, 10, 64); err == nil {
+			q.Step = time.Duration(secs) * time.Second
+		} else if q.Step, err = time.ParseDuration(step); err != nil {
+			return q, fmt.Errorf("invalid step parameter: %s", err.Error())
+		}
+		if q.Step <= 0 {
+			return q, fmt.Errorf("invalid step parameter: must be positive")
+		}
+	}
+	if hashes := c.QueryParam("hashes"); hashes != "" {
+		if q.Hashes, err = strconv.ParseBool(hashes); err != nil {
+			return q, fmt.Errorf("invalid hashes parameter: %s", err.Error())
+		}
+	}
+	return q, nil
+}
+
+func parseQueryTime(param string) (time.Time, error) {
+	if param == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(param, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, param)
+}
+
+// probe defaults used when not defined in the config.
+const (
+	defaultBatchSize     = 5
+	defaultPointInterval = 60
+	defaultMaxRetries    = 5
+)
+
+// the amount of transactions making up a point, the interval in which
+// points are filled and how often a failed send is retried.
+var batchSize int
+var pointInterval time.Duration
+var maxRetries int
+
+// counters exposed via the metrics endpoint, accessed atomically.
+var (
+	sentTotal      uint64
+	confirmedTotal uint64
+	sendFailures   uint64
+	sendRetries    uint64
+	sendsSkipped   uint64
+)
+
+// the time (unix nano) of the last successfully sent transaction
+// and the error of the last failed send, accessed atomically.
+var lastSendSuccess int64
+var lastSendError atomic.Value
+
+// replays the records of the given store into the measurer and returns the send time
+// of each transaction which isn't confirmed yet. points which started before the retention
+// of the measurer are skipped, as they would otherwise be served as current after a long outage.
+func restore(measurer *measurement.Measurer, st *store.Store) (map[Hash]time.Time, error) {
+	records, err := st.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// the sent records make up the points in the order they were recorded
+	cutoff := time.Now().Add(-measurer.Retention())
+	stale := map[Hash]struct{}{}
+	sent := 0
+	var stalePoint bool
+	for _, rec := range records {
+		if rec.Kind != store.RecordSent {
+			continue
+		}
+		if sent%measurer.BatchSize() == 0 {
+			stalePoint = rec.Time.Before(cutoff)
+		}
+		sent++
+		if stalePoint {
+			stale[rec.Hash] = struct{}{}
+		}
+	}
+
+	sentAt := map[Hash]time.Time{}
+	for _, rec := range records {
+		if _, has := stale[rec.Hash]; has {
+			continue
+		}
+		switch rec.Kind {
+		case store.RecordSent:
+			measurer.RecordSentAt(rec.Hash, rec.Time)
+			sentAt[rec.Hash] = rec.Time
+		case store.RecordConfirmed:
+			measurer.RecordConfirmedAt(rec.Hash, rec.Time)
+		}
+	}
+	if err := compact(measurer, st); err != nil {
+		return nil, err
+	}
+	unconfirmed := map[Hash]time.Time{}
+	for hash, confirmed := range measurer.Retained() {
+		if !confirmed {
+			unconfirmed[hash] = sentAt[hash]
+		}
+	}
+	logger.Infof("restored %d points from the store (%d unconfirmed txs)", measurer.PointsFilled(), len(unconfirmed))
+	return unconfirmed, nil
+}
+
+// rewrites the store to only contain records of retained points.
+func compact(measurer *measurement.Measurer, st *store.Store) error {
+	records, err := st.Load()
+	if err != nil {
+		return err
+	}
+	retained := measurer.Retained()
+	kept := make([]store.Record, 0, len(records))
+	for _, rec := range records {
+		if _, has := retained[rec.Hash]; has {
+			kept = append(kept, rec)
+		}
+	}
+	return st.Compact(kept)
+}
+
+// persists the given record if a store is used.
+func persist(st *store.Store, kind store.RecordKind, hash Hash, ts time.Time) {
+	if st == nil {
+		return
+	}
+	if err := st.Append(store.Record{Kind: kind, Hash: hash, Time: ts}); err != nil {
+		logger.Errorf("unable to persist %s record for %s: %s", kind, hash, err.Error())
+	}
+}
+
+// checks whether the given restored transactions got confirmed, as the account
+// doesn't know about transactions which were sent before a restart.
+func trackRestored(iotaAPI *api.API, unconfirmed map[Hash]time.Time, retention time.Duration, interval time.Duration, confirmed chan<- Hash) {
+	for len(unconfirmed) > 0 {
+		// give up on transactions which fell out of the retention policy anyway
+		hashes := Hashes{}
+		now := time.Now()
+		for hash, sentAt := range unconfirmed {
+			if now.Sub(sentAt) > retention {
+				delete(unconfirmed, hash)
+				continue
+			}
+			hashes = append(hashes, hash)
+		}
+		if len(hashes) == 0 {
+			return
+		}
+		states, err := iotaAPI.GetLatestInclusion(hashes)
+		if err != nil {
+			logger.Errorf("unable to check inclusion states of restored txs: %s", err.Error())
+			time.Sleep(interval)
+			continue
+		}
+		for i, state := range states {
+			if state {
+				confirmed <- hashes[i]
+				delete(unconfirmed, hashes[i])
 			}
+		}
+		time.Sleep(interval)
+	}
+}
 
-			backResult <- models.ConfRate{
-				buckets[0].rate(),
-				buckets[1].rate(),
-				buckets[2].rate(),
-				buckets[3].rate(),
+// feeds the sent and confirmed transfer events into the measurer, persists them
+// and publishes them together with the updated results to the broker.
+func measure(em event.EventMachine, measurer *measurement.Measurer, st *store.Store, restoredConfirmed chan Hash,
+	broker *stream.Broker, exposedConfig models.ExposedConfig) {
+	lis := listener.NewChannelEventListener(em).RegConfirmedTransfers().RegSentTransfers()
+
+	publishResult := func() {
+		if !broker.HasSubscribers() {
+			return
+		}
+		broker.Publish(stream.Event{
+			Type: stream.EventResult,
+			Data: models.Response{Config: exposedConfig, Results: measurer.Rates()},
+		})
+	}
+
+	confirm := func(hash Hash) {
+		now := time.Now()
+		if measurer.RecordConfirmedAt(hash, now) {
+			logger.Debugf("set tx to be confirmed")
+			persist(st, store.RecordConfirmed, hash, now)
+			atomic.AddUint64(&confirmedTotal, 1)
+			broker.Publish(stream.Event{
+				Type: stream.EventConfirmed,
+				Data: models.TransactionEvent{Hash: hash, Time: now},
+			})
+			publishResult()
+		}
+	}
+
+	for {
+		select {
+		case e := <-lis.SentTransfer:
+			logger.Debugf("got sent transfer event %s", e[0].Hash)
+			now := time.Now()
+			persist(st, store.RecordSent, e[0].Hash, now)
+			atomic.AddUint64(&sentTotal, 1)
+			broker.Publish(stream.Event{
+				Type: stream.EventSent,
+				Data: models.TransactionEvent{Hash: e[0].Hash, Time: now},
+			})
+			if measurer.RecordSentAt(e[0].Hash, now) {
+				publishResult()
+				pointsFilled := measurer.PointsFilled()
+				logger.Debugf("filled point with %d txs (points filled: %d)", batchSize, pointsFilled)
+				// keep the store from growing indefinitely
+				if st != nil && pointsFilled%measurer.RetentionPolicy() == 0 {
+					if err := compact(measurer, st); err != nil {
+						logger.Errorf("unable to compact store: %s", err.Error())
+					}
+				}
 			}
+		case e := <-lis.TransferConfirmed:
+			logger.Debugf("got transfer confirmed event %s", e[0].Hash)
+			confirm(e[0].Hash)
+		case hash := <-restoredConfirmed:
+			logger.Debugf("restored tx %s got confirmed", hash)
+			confirm(hash)
 		}
 	}
 }
 
+// sets up the batch size, point interval and max retries of the probe
+// transactions, falling back to the defaults for undefined values.
+func initProbe(probe *models.ProbeConfig) {
+	if probe.BatchSize == 0 {
+		probe.BatchSize = defaultBatchSize
+	}
+	if probe.PointInterval == 0 {
+		probe.PointInterval = defaultPointInterval
+	}
+	if probe.MaxRetries == nil {
+		retries := uint64(defaultMaxRetries)
+		probe.MaxRetries = &retries
+	}
+	batchSize = int(probe.BatchSize)
+	pointInterval = time.Duration(probe.PointInterval) * time.Second
+	maxRetries = int(*probe.MaxRetries)
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)
@@ -279,18 +843,52 @@ func randAddr() Hash {
 
 type config struct {
 	models.ExposedConfig
-	Listen            string `json:"listen"`
-	LocalPow          bool   `json:"local_pow"`
-	Debug             bool   `json:"debug"`
-	ResultLogInterval uint64 `json:"result_log_interval"`
+	Listen            string  `json:"listen"`
+	LocalPow          bool    `json:"local_pow"`
+	Debug             bool    `json:"debug"`
+	ResultLogInterval uint64  `json:"result_log_interval"`
+	StorePath         string  `json:"store_path"`
+	Windows           []int   `json:"windows"`
+	Confidence        float64 `json:"confidence"`
 	Quorum            struct {
-		PrimaryNode                string   `json:"primary_node"`
-		Nodes                      []string `json:"nodes"`
-		Threshold                  float64  `json:"threshold"`
-		NoResponseTolerance        float64  `json:"no_response_tolerance"`
-		MaxSubtangleMilestoneDelta uint64   `json:"max_subtangle_milestone_delta"`
-		Timeout                    uint64   `json:"timeout"`
+		PrimaryNode   string             `json:"primary_node"`
+		FailoverNodes []string           `json:"failover_nodes"`
+		Selection     string             `json:"selection"`
+		Nodes         []string           `json:"nodes"`
+		NodeWeights   map[string]float64 `json:"node_weights"`
+		Policies      map[string]struct {
+			Threshold           *float64 `json:"threshold"`
+			NoResponseTolerance *float64 `json:"no_response_tolerance"`
+			AcceptAny           bool     `json:"accept_any"`
+			MinAccepted         int      `json:"min_accepted"`
+			MinAcceptedShare    float64  `json:"min_accepted_share"`
+		} `json:"policies"`
+		Threshold                  float64                      `json:"threshold"`
+		NoResponseTolerance        float64                      `json:"no_response_tolerance"`
+		MaxSubtangleMilestoneDelta uint64                       `json:"max_subtangle_milestone_delta"`
+		Timeout                    uint64                       `json:"timeout"`
+		PerElementQuorum           bool                         `json:"per_element_quorum"`
+		ReportLogSize              int                          `json:"report_log_size"`
+		ExcludeLaggingNodes        bool                         `json:"exclude_lagging_nodes"`
+		MinSyncedNodes             int                          `json:"min_synced_nodes"`
+		QuarantineDuration         uint64                       `json:"quarantine_duration"`
+		FailoverBackoff            uint64                       `json:"failover_backoff"`
+		Headers                    map[string]string            `json:"headers"`
+		NodeHeaders                map[string]map[string]string `json:"node_headers"`
+		Defaults                   struct {
+			GetInclusionStates     *bool `json:"get_inclusion_states"`
+			WereAddressesSpentFrom *bool `json:"were_addresses_spent_from"`
+		} `json:"defaults"`
+		CircuitBreaker struct {
+			Enabled          bool   `json:"enabled"`
+			FailureThreshold int    `json:"failure_threshold"`
+			OpenDuration     uint64 `json:"open_duration"`
+		} `json:"circuit_breaker"`
 	} `json:"quorum"`
+	Health struct {
+		MaxSendAge   uint64 `json:"max_send_age"`
+		ReadyWindows []int  `json:"ready_windows"`
+	} `json:"health"`
 }
 
 func readConfig() *config {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/Mandala/go-log"
+	"github.com/luca-moser/confbox/measurement"
+	"github.com/luca-moser/confbox/store"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreSkipsStalePoints(t *testing.T) {
+	logger = log.New(os.Stdout)
+	measurer, err := measurement.New(measurement.Settings{BatchSize: 2, PointInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("unable to create measurer: %v", err)
+	}
+	st, err := store.New(filepath.Join(t.TempDir(), "store.jsonl"))
+	if err != nil {
+		t.Fatalf("unable to create store: %v", err)
+	}
+	defer st.Close()
+
+	// a point sent off before the retention and one within it
+	stale := time.Now().Add(-2 * measurer.Retention())
+	recent := time.Now().Add(-time.Minute)
+	records := []store.Record{
+		{Kind: store.RecordSent, Hash: "A", Time: stale},
+		{Kind: store.RecordSent, Hash: "B", Time: stale},
+		{Kind: store.RecordConfirmed, Hash: "A", Time: stale.Add(time.Minute)},
+		{Kind: store.RecordSent, Hash: "C", Time: recent},
+		{Kind: store.RecordSent, Hash: "D", Time: recent},
+		{Kind: store.RecordConfirmed, Hash: "C", Time: recent.Add(time.Second)},
+	}
+	for _, rec := range records {
+		if err := st.Append(rec); err != nil {
+			t.Fatalf("unable to append record: %v", err)
+		}
+	}
+
+	unconfirmed, err := restore(measurer, st)
+	if err != nil {
+		t.Fatalf("unable to restore: %v", err)
+	}
+	if len(unconfirmed) != 1 || !unconfirmed["D"].Equal(recent) {
+		t.Fatalf("expected only D to be unconfirmed, got %v", unconfirmed)
+	}
+	retained := measurer.Retained()
+	if len(retained) != 2 || !retained["C"] || retained["D"] {
+		t.Fatalf("expected only the recent point to be retained, got %v", retained)
+	}
+	if filled := measurer.PointsFilled(); filled != 1 {
+		t.Fatalf("expected 1 restored point, got %d", filled)
+	}
+
+	// the stale records are compacted away
+	compacted, err := st.Load()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+	if len(compacted) != 3 || compacted[0].Hash != "C" {
+		t.Fatalf("expected only the records of the recent point, got %+v", compacted)
+	}
+}
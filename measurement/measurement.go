@@ -0,0 +1,504 @@
+package measurement
+
+import (
+	"container/ring"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/luca-moser/confbox/models"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errors produced by the measurer
+var (
+	ErrInvalidBatchSize     = errors.New("batch size must be greater than 0")
+	ErrInvalidPointInterval = errors.New("point interval must be greater than 0")
+	ErrInvalidWindow        = errors.New("windows must be greater than 0")
+	ErrInvalidConfidence    = errors.New("confidence must be within 0<x<1")
+)
+
+// DefaultWindows are the windows (in minutes) for which the avg. conf. rate
+// is computed if none are defined in the settings.
+var DefaultWindows = []int{5, 10, 15, 30}
+
+// DefaultLatencyBounds are the upper bounds (seconds) of the confirmation latency
+// histogram buckets used if none are defined in the settings.
+var DefaultLatencyBounds = []float64{30, 60, 120, 300, 600, 900, 1800, 3600}
+
+// DefaultConfidence is the confidence level of the conf. rate intervals
+// used if none is defined in the settings.
+const DefaultConfidence = 0.95
+
+// the windows backing the Avg5, Avg10, Avg15 and Avg30 results.
+var legacyWindows = [4]int{5, 10, 15, 30}
+
+// Clock provides the current time to the Measurer.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock returning the system's current time.
+type SystemClock struct{}
+
+// Now returns the system's current time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Settings defines the parameters of a Measurer.
+type Settings struct {
+	// The amount of transactions making up a point.
+	BatchSize int
+	// The interval in which points are filled.
+	PointInterval time.Duration
+	// The windows (in minutes) for which the avg. conf. rate is computed.
+	// Defaults to DefaultWindows.
+	Windows []int
+	// The upper bounds (seconds) of the confirmation latency histogram buckets.
+	// Defaults to DefaultLatencyBounds.
+	LatencyBounds []float64
+	// The confidence level (0<x<1) of the conf. rate intervals. Defaults to DefaultConfidence.
+	Confidence float64
+	// The Clock used to timestamp sent and confirmed transactions. Defaults to SystemClock.
+	Clock Clock
+}
+
+// HistoryQuery defines which retained points to return and whether
+// to aggregate them into steps of the given duration.
+type HistoryQuery struct {
+	// Only return points starting at or after From, if set.
+	From time.Time
+	// Only return points starting at or before To, if set.
+	To time.Time
+	// Aggregate the points into steps of the given duration, if set.
+	Step time.Duration
+	// Include the transactions of each point.
+	Hashes bool
+}
+
+// sample holds the time a transaction was sent off and the time
+// it was confirmed, the latter being zero as long as it is pending.
+type sample struct {
+	sent      time.Time
+	confirmed time.Time
+}
+
+func (s *sample) isConfirmed() bool {
+	return !s.confirmed.IsZero()
+}
+
+// point holds the samples of a batch of transactions and
+// the time the first transaction of the batch was sent off.
+type point struct {
+	start   time.Time
+	samples map[trinary.Hash]*sample
+}
+
+// Measurer keeps track of sent off transactions in points of a fixed amount of transactions
+// and computes the avg. confirmation rate over windows of the retained points.
+// Measurer is safe for concurrent use.
+type Measurer struct {
+	mu              sync.RWMutex
+	clock           Clock
+	batchSize       int
+	pointInterval   time.Duration
+	windows         []int
+	latencyBounds   []float64
+	z               float64
+	retentionPolicy int
+	points          *ring.Ring
+	pointsFilled    int
+	gathered        int
+}
+
+// New creates a new Measurer with the given settings. The ring buffer is sized
+// to retain enough points for the largest window, including the legacy windows.
+func New(settings Settings) (*Measurer, error) {
+	if settings.BatchSize <= 0 {
+		return nil, ErrInvalidBatchSize
+	}
+	if settings.PointInterval <= 0 {
+		return nil, ErrInvalidPointInterval
+	}
+	m := &Measurer{
+		clock:         settings.Clock,
+		batchSize:     settings.BatchSize,
+		pointInterval: settings.PointInterval,
+		latencyBounds: settings.LatencyBounds,
+	}
+	if m.clock == nil {
+		m.clock = SystemClock{}
+	}
+	if len(m.latencyBounds) == 0 {
+		m.latencyBounds = DefaultLatencyBounds
+	}
+	confidence := settings.Confidence
+	if confidence == 0 {
+		confidence = DefaultConfidence
+	}
+	if confidence <= 0 || confidence >= 1 {
+		return nil, errors.Wrapf(ErrInvalidConfidence, "got %v", confidence)
+	}
+	// the standard normal quantile for the two-sided interval
+	m.z = math.Sqrt2 * math.Erfinv(confidence)
+
+	configured := settings.Windows
+	if len(configured) == 0 {
+		configured = DefaultWindows
+	}
+	m.windows = make([]int, 0, len(configured))
+	seen := map[int]struct{}{}
+	largest := legacyWindows[len(legacyWindows)-1]
+	for _, window := range configured {
+		if window <= 0 {
+			return nil, errors.Wrapf(ErrInvalidWindow, "got %d", window)
+		}
+		if _, has := seen[window]; has {
+			continue
+		}
+		seen[window] = struct{}{}
+		m.windows = append(m.windows, window)
+		if window > largest {
+			largest = window
+		}
+	}
+	sort.Ints(m.windows)
+	// one additional point for the one currently being filled
+	m.retentionPolicy = m.windowPoints(largest) + 1
+	m.points = ring.New(m.retentionPolicy)
+	return m, nil
+}
+
+// returns the amount of points needed to cover the given window (minutes).
+func (m *Measurer) windowPoints(window int) int {
+	return int(math.Ceil(float64(time.Duration(window)*time.Minute) / float64(m.pointInterval)))
+}
+
+// RecordSent adds the given hash to the current point, timestamped with the current time of the Clock.
+// Returns true if the current point gathered all its transactions and the Measurer moved on to the next point.
+func (m *Measurer) RecordSent(hash trinary.Hash) bool {
+	return m.RecordSentAt(hash, m.clock.Now())
+}
+
+// RecordSentAt is like RecordSent but uses the given time, i.e. when replaying persisted data.
+func (m *Measurer) RecordSentAt(hash trinary.Hash, ts time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.points.Value.(*point)
+	// either never used or we have looped in the ring buffer
+	if !ok || m.gathered == 0 {
+		p = &point{start: ts, samples: map[trinary.Hash]*sample{}}
+	}
+	p.samples[hash] = &sample{sent: ts}
+	m.points.Value = p
+	m.gathered++
+	// gathered all tx for this point, lets forward to the next
+	if m.gathered == m.batchSize {
+		m.pointsFilled++
+		m.gathered = 0
+		m.points = m.points.Next()
+		return true
+	}
+	return false
+}
+
+// RecordConfirmed marks the given hash as confirmed at the current time of the Clock.
+// Returns false if the hash isn't part of any retained point or was already confirmed.
+func (m *Measurer) RecordConfirmed(hash trinary.Hash) bool {
+	return m.RecordConfirmedAt(hash, m.clock.Now())
+}
+
+// RecordConfirmedAt is like RecordConfirmed but uses the given time, i.e. when replaying persisted data.
+func (m *Measurer) RecordConfirmedAt(hash trinary.Hash, ts time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.points
+	for i := 0; i < m.retentionPolicy; i++ {
+		p, ok := r.Value.(*point)
+		if !ok {
+			r = r.Prev()
+			continue
+		}
+		if s, has := p.samples[hash]; has {
+			if s.isConfirmed() {
+				return false
+			}
+			s.confirmed = ts
+			return true
+		}
+		r = r.Prev()
+	}
+	return false
+}
+
+// PointsFilled returns the amount of points filled since the creation of the Measurer.
+func (m *Measurer) PointsFilled() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pointsFilled
+}
+
+// BatchSize returns the amount of transactions making up a point.
+func (m *Measurer) BatchSize() int {
+	return m.batchSize
+}
+
+// RetentionPolicy returns the amount of points retained, including the one currently being filled.
+func (m *Measurer) RetentionPolicy() int {
+	return m.retentionPolicy
+}
+
+// Retention returns the time span covered by the retained points.
+func (m *Measurer) Retention() time.Duration {
+	return time.Duration(m.retentionPolicy) * m.pointInterval
+}
+
+// Windows returns the ascending sorted windows (in minutes) for which the avg. conf. rate is computed.
+func (m *Measurer) Windows() []int {
+	return append([]int{}, m.windows...)
+}
+
+// calls the given function for each retained point from the oldest to the newest one.
+// the point currently being filled is passed last with filled set to false.
+// the caller must hold the lock.
+func (m *Measurer) eachRetainedPoint(f func(p *point, filled bool)) {
+	r := m.points.Next()
+	for i := 0; i < m.retentionPolicy; i++ {
+		// the current point holds stale data from the previous loop
+		// through the ring buffer as long as nothing was gathered for it
+		if r == m.points && m.gathered == 0 {
+			break
+		}
+		if p, ok := r.Value.(*point); ok {
+			f(p, r != m.points)
+		}
+		r = r.Next()
+	}
+}
+
+// Retained returns the hashes of all retained points mapped to their confirmation state.
+func (m *Measurer) Retained() map[trinary.Hash]bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	retained := map[trinary.Hash]bool{}
+	m.eachRetainedPoint(func(p *point, filled bool) {
+		for hash, s := range p.samples {
+			retained[hash] = s.isConfirmed()
+		}
+	})
+	return retained
+}
+
+// History returns the retained points matching the given query from the oldest to the newest one.
+func (m *Measurer) History(q HistoryQuery) []models.Point {
+	m.mu.RLock()
+	history := []models.Point{}
+	m.eachRetainedPoint(func(pt *point, filled bool) {
+		p := models.Point{Start: pt.start, Filled: filled}
+		for hash, s := range pt.samples {
+			p.Sent++
+			if s.isConfirmed() {
+				p.Confirmed++
+			}
+			if q.Hashes {
+				tx := models.PointTransaction{Hash: hash, SentAt: s.sent}
+				if s.isConfirmed() {
+					confirmedAt := s.confirmed
+					tx.ConfirmedAt = &confirmedAt
+				}
+				p.Transactions = append(p.Transactions, tx)
+			}
+		}
+		if (!q.From.IsZero() && p.Start.Before(q.From)) || (!q.To.IsZero() && p.Start.After(q.To)) {
+			return
+		}
+		if q.Hashes {
+			sort.Slice(p.Transactions, func(i, j int) bool {
+				return p.Transactions[i].SentAt.Before(p.Transactions[j].SentAt)
+			})
+		}
+		p.Rate = pointRate(p.Sent, p.Confirmed)
+		history = append(history, p)
+	})
+	m.mu.RUnlock()
+	if q.Step == 0 || len(history) == 0 {
+		return history
+	}
+
+	// aggregate the points into steps aligned to the start of the query
+	origin := q.From
+	if origin.IsZero() {
+		origin = history[0].Start
+	}
+	aggregated := []models.Point{}
+	for _, p := range history {
+		stepStart := origin.Add(p.Start.Sub(origin) / q.Step * q.Step)
+		if len(aggregated) == 0 || !aggregated[len(aggregated)-1].Start.Equal(stepStart) {
+			aggregated = append(aggregated, models.Point{Start: stepStart, Filled: true})
+		}
+		agg := &aggregated[len(aggregated)-1]
+		agg.Sent += p.Sent
+		agg.Confirmed += p.Confirmed
+		agg.Filled = agg.Filled && p.Filled
+		agg.Transactions = append(agg.Transactions, p.Transactions...)
+		agg.Rate = pointRate(agg.Sent, agg.Confirmed)
+	}
+	return aggregated
+}
+
+func pointRate(sent int, confirmed int) float64 {
+	if sent == 0 {
+		return -1
+	}
+	return math.Floor((float64(confirmed)/float64(sent))*100) / 100
+}
+
+// Rates computes the avg. conf. rate and confirmation latency of each window
+// and of the legacy windows for the compatibility view.
+func (m *Measurer) Rates() models.ConfRate {
+	all := append([]int{}, m.windows...)
+	all = append(all, legacyWindows[:]...)
+	sort.Ints(all)
+	sizes := make([]int, len(all))
+	for i, window := range all {
+		sizes[i] = m.windowPoints(window)
+	}
+	m.mu.RLock()
+	buckets := m.computeBuckets(sizes)
+	m.mu.RUnlock()
+	byWindow := make(map[int]*bucket, len(all))
+	for i, window := range all {
+		byWindow[window] = &buckets[i]
+	}
+
+	windowRate := func(window int) models.WindowRate {
+		b := byWindow[window]
+		lower, upper := b.interval(m.z)
+		return models.WindowRate{
+			Window:    window,
+			Rate:      b.rate(),
+			Samples:   int(b.size),
+			Confirmed: int(b.confirmed),
+			Lower:     lower,
+			Upper:     upper,
+			Latency:   b.latency(m.latencyBounds),
+		}
+	}
+
+	result := models.ConfRate{
+		Avg5:          byWindow[legacyWindows[0]].rate(),
+		Avg10:         byWindow[legacyWindows[1]].rate(),
+		Avg15:         byWindow[legacyWindows[2]].rate(),
+		Avg30:         byWindow[legacyWindows[3]].rate(),
+		Windows:       make([]models.WindowRate, len(m.windows)),
+		LegacyWindows: make([]models.WindowRate, len(legacyWindows)),
+	}
+	for i, window := range m.windows {
+		result.Windows[i] = windowRate(window)
+	}
+	for i, window := range legacyWindows {
+		result.LegacyWindows[i] = windowRate(window)
+	}
+	return result
+}
+
+type bucket struct {
+	ok        bool
+	size      float64
+	confirmed float64
+	latencies []float64
+}
+
+func (b *bucket) rate() float64 {
+	if !b.ok {
+		return -1
+	}
+	return math.Floor((b.confirmed/b.size)*100) / 100
+}
+
+// computes the Wilson score interval of the conf. rate for the given standard normal quantile.
+// the lower bound is floored and the upper bound ceiled to two decimals.
+func (b *bucket) interval(z float64) (float64, float64) {
+	if !b.ok || b.size == 0 {
+		return -1, -1
+	}
+	n := b.size
+	p := b.confirmed / n
+	z2 := z * z
+	denominator := 1 + z2/n
+	center := (p + z2/(2*n)) / denominator
+	halfWidth := z * math.Sqrt(p*(1-p)/n+z2/(4*n*n)) / denominator
+	lower := math.Max(0, math.Floor((center-halfWidth)*100)/100)
+	upper := math.Min(1, math.Ceil((center+halfWidth)*100)/100)
+	return lower, upper
+}
+
+// computes the confirmation latency percentiles and histogram of the bucket.
+func (b *bucket) latency(bounds []float64) models.Latency {
+	if !b.ok || len(b.latencies) == 0 {
+		return models.Latency{P50: -1, P90: -1, P99: -1}
+	}
+	sorted := make([]float64, len(b.latencies))
+	copy(sorted, b.latencies)
+	sort.Float64s(sorted)
+
+	histogram := make([]models.LatencyBucket, len(bounds))
+	for i, bound := range bounds {
+		histogram[i] = models.LatencyBucket{LE: bound, Count: sort.Search(len(sorted), func(j int) bool {
+			return sorted[j] > bound
+		})}
+	}
+
+	return models.Latency{
+		P50:       percentile(sorted, 0.5),
+		P90:       percentile(sorted, 0.9),
+		P99:       percentile(sorted, 0.99),
+		Count:     len(sorted),
+		Histogram: histogram,
+	}
+}
+
+// returns the nearest-rank percentile of the given ascending sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return math.Floor(sorted[rank]*100) / 100
+}
+
+// computes a bucket for each of the given ascending sorted sizes (in points) by walking
+// back from the last filled point. buckets for which not enough points are filled yet
+// or whose points don't cover the recent past, i.e. after an outage, are not ok.
+// the caller must hold the lock.
+func (m *Measurer) computeBuckets(sizes []int) []bucket {
+	buckets := make([]bucket, len(sizes))
+	now := m.clock.Now()
+	var b bucket
+	r := m.points.Prev()
+	walked := 0
+	for i, size := range sizes {
+		// besides the points of the window, the span covers the point currently
+		// being filled and one interval of slack for delayed sends
+		cutoff := now.Add(-time.Duration(size+2) * m.pointInterval)
+		for ; walked < size; walked++ {
+			p, ok := r.Value.(*point)
+			if !ok || p.start.Before(cutoff) {
+				return buckets
+			}
+			for _, s := range p.samples {
+				b.size++
+				if s.isConfirmed() {
+					b.confirmed++
+					b.latencies = append(b.latencies, s.confirmed.Sub(s.sent).Seconds())
+				}
+			}
+			r = r.Prev()
+		}
+		buckets[i] = b
+		buckets[i].ok = true
+	}
+	return buckets
+}
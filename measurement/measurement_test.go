@@ -0,0 +1,341 @@
+package measurement
+
+import (
+	"fmt"
+	"github.com/iotaledger/iota.go/trinary"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock which only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMeasurer(t *testing.T, batchSize int, pointInterval time.Duration, clock Clock) *Measurer {
+	t.Helper()
+	m, err := New(Settings{BatchSize: batchSize, PointInterval: pointInterval, Windows: []int{5}, Clock: clock})
+	if err != nil {
+		t.Fatalf("unable to create measurer: %v", err)
+	}
+	return m
+}
+
+func hash(point int, tx int) trinary.Hash {
+	return fmt.Sprintf("P%dT%d", point, tx)
+}
+
+// fills the given amount of points, confirming the given amount of transactions of each point
+// after the given latency. the clock moves by the point interval after each point.
+func fillPoints(m *Measurer, clock *fakeClock, points int, confirmed int, latency time.Duration) {
+	for p := 0; p < points; p++ {
+		for tx := 0; tx < m.batchSize; tx++ {
+			m.RecordSent(hash(p, tx))
+		}
+		for tx := 0; tx < confirmed; tx++ {
+			m.RecordConfirmedAt(hash(p, tx), clock.Now().Add(latency))
+		}
+		clock.advance(m.pointInterval)
+	}
+}
+
+func TestRecordSentAndConfirmed(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	m := newTestMeasurer(t, 2, time.Minute, clock)
+
+	if m.RecordSent("A") {
+		t.Fatal("point must not be filled after the first transaction")
+	}
+	clock.advance(10 * time.Second)
+	if !m.RecordSent("B") {
+		t.Fatal("point must be filled after the second transaction")
+	}
+	if filled := m.PointsFilled(); filled != 1 {
+		t.Fatalf("expected 1 filled point, got %d", filled)
+	}
+
+	clock.advance(30 * time.Second)
+	if !m.RecordConfirmed("A") {
+		t.Fatal("expected A to be confirmed")
+	}
+	if m.RecordConfirmed("A") {
+		t.Fatal("A must not be confirmed twice")
+	}
+	if m.RecordConfirmed("C") {
+		t.Fatal("unknown hashes must not be confirmed")
+	}
+
+	retained := m.Retained()
+	if len(retained) != 2 || !retained["A"] || retained["B"] {
+		t.Fatalf("unexpected retained hashes: %v", retained)
+	}
+
+	history := m.History(HistoryQuery{Hashes: true})
+	if len(history) != 1 {
+		t.Fatalf("expected 1 point, got %d", len(history))
+	}
+	p := history[0]
+	if !p.Start.Equal(time.Unix(1000, 0)) || p.Sent != 2 || p.Confirmed != 1 || p.Rate != 0.5 || !p.Filled {
+		t.Fatalf("unexpected point: %+v", p)
+	}
+	if tx := p.Transactions[0]; tx.Hash != "A" || tx.ConfirmedAt == nil || !tx.ConfirmedAt.Equal(time.Unix(1040, 0)) {
+		t.Fatalf("unexpected transaction: %+v", tx)
+	}
+}
+
+func TestRecordSentAtWrapAround(t *testing.T) {
+	// a point interval of 10 minutes retains 3 points for the legacy 30 minutes
+	// window plus the one currently being filled
+	m := newTestMeasurer(t, 2, 10*time.Minute, &fakeClock{})
+	if policy := m.RetentionPolicy(); policy != 4 {
+		t.Fatalf("expected a retention policy of 4, got %d", policy)
+	}
+
+	start := time.Unix(0, 0)
+	for p := 0; p < 6; p++ {
+		for tx := 0; tx < 2; tx++ {
+			m.RecordSentAt(hash(p, tx), start.Add(time.Duration(p)*10*time.Minute))
+		}
+	}
+	// one transaction into the point reusing the slot of the third point
+	m.RecordSentAt(hash(6, 0), start.Add(60*time.Minute))
+
+	retained := m.Retained()
+	for p := 0; p < 3; p++ {
+		if _, has := retained[hash(p, 0)]; has {
+			t.Fatalf("point %d must have been overwritten", p)
+		}
+	}
+	for _, h := range []trinary.Hash{hash(3, 0), hash(4, 1), hash(5, 0), hash(6, 0)} {
+		if _, has := retained[h]; !has {
+			t.Fatalf("expected %s to be retained", h)
+		}
+	}
+	if len(retained) != 7 {
+		t.Fatalf("expected 7 retained hashes, got %d", len(retained))
+	}
+	if m.RecordConfirmedAt(hash(0, 0), start) {
+		t.Fatal("overwritten hashes must not be confirmed")
+	}
+
+	history := m.History(HistoryQuery{})
+	if len(history) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(history))
+	}
+	if !history[0].Start.Equal(start.Add(30*time.Minute)) || !history[3].Start.Equal(start.Add(60*time.Minute)) {
+		t.Fatalf("unexpected order of points: %v - %v", history[0].Start, history[3].Start)
+	}
+	if history[3].Filled || history[3].Sent != 1 {
+		t.Fatalf("expected the current point to be partially filled: %+v", history[3])
+	}
+}
+
+func TestWindowPoints(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		window   int
+		points   int
+	}{
+		{time.Minute, 5, 5},
+		{time.Minute, 30, 30},
+		{30 * time.Second, 5, 10},
+		{2 * time.Minute, 5, 3},
+		{2 * time.Minute, 30, 15},
+		{7 * time.Minute, 5, 1},
+		{7 * time.Minute, 15, 3},
+	}
+	for _, test := range tests {
+		m := newTestMeasurer(t, 1, test.interval, &fakeClock{})
+		if points := m.windowPoints(test.window); points != test.points {
+			t.Errorf("interval %v, window %d: expected %d points, got %d", test.interval, test.window, test.points, points)
+		}
+	}
+}
+
+func TestComputeBuckets(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	// with a point interval of 2 minutes the 5 minutes window covers 3 points
+	m := newTestMeasurer(t, 4, 2*time.Minute, clock)
+	sizes := []int{m.windowPoints(5), m.windowPoints(10)}
+	if sizes[0] != 3 || sizes[1] != 5 {
+		t.Fatalf("unexpected window sizes: %v", sizes)
+	}
+
+	fillPoints(m, clock, 2, 4, time.Minute)
+	m.mu.RLock()
+	buckets := m.computeBuckets(sizes)
+	m.mu.RUnlock()
+	if buckets[0].ok || buckets[1].ok {
+		t.Fatal("buckets must not be ok before enough points are filled")
+	}
+
+	// the older points are fully confirmed, the 3 latest ones half confirmed
+	fillPoints(m, clock, 3, 2, 2*time.Minute)
+	m.mu.RLock()
+	buckets = m.computeBuckets(sizes)
+	m.mu.RUnlock()
+
+	if b := buckets[0]; !b.ok || b.size != 12 || b.confirmed != 6 || b.rate() != 0.5 || len(b.latencies) != 6 {
+		t.Fatalf("unexpected bucket of the 5 minutes window: %+v", b)
+	}
+	if b := buckets[1]; !b.ok || b.size != 20 || b.confirmed != 14 || b.rate() != 0.7 {
+		t.Fatalf("unexpected bucket of the 10 minutes window: %+v", b)
+	}
+
+	rates := m.Rates()
+	if rates.Avg5 != 0.5 || rates.Avg10 != 0.7 || rates.Avg15 != -1 || rates.Avg30 != -1 {
+		t.Fatalf("unexpected legacy rates: %+v", rates)
+	}
+	window := rates.Windows[0]
+	if window.Window != 5 || window.Rate != 0.5 || window.Samples != 12 || window.Confirmed != 6 || window.Latency.P50 != 120 {
+		t.Fatalf("unexpected rate of the 5 minutes window: %+v", window)
+	}
+	if len(rates.LegacyWindows) != 4 || rates.LegacyWindows[1].Window != 10 || rates.LegacyWindows[1].Samples != 20 || rates.LegacyWindows[1].Lower == -1 {
+		t.Fatalf("expected the legacy windows regardless of the configured windows: %+v", rates.LegacyWindows)
+	}
+}
+
+func TestComputeBucketsAfterOutage(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	m := newTestMeasurer(t, 4, 2*time.Minute, clock)
+	sizes := []int{m.windowPoints(5)}
+	fillPoints(m, clock, 5, 4, time.Minute)
+
+	// an outage shorter than the retention leaves points which don't cover the window
+	clock.advance(10 * time.Minute)
+	fillPoints(m, clock, 1, 2, time.Minute)
+	m.mu.RLock()
+	buckets := m.computeBuckets(sizes)
+	m.mu.RUnlock()
+	if buckets[0].ok {
+		t.Fatalf("the bucket must not be ok while its points don't cover the window: %+v", buckets[0])
+	}
+	if rate := m.Rates().Windows[0].Rate; rate != -1 {
+		t.Fatalf("expected no rate after the outage, got %v", rate)
+	}
+
+	fillPoints(m, clock, 2, 2, time.Minute)
+	m.mu.RLock()
+	buckets = m.computeBuckets(sizes)
+	m.mu.RUnlock()
+	if b := buckets[0]; !b.ok || b.size != 12 || b.confirmed != 6 {
+		t.Fatalf("unexpected bucket after the window was filled again: %+v", b)
+	}
+}
+
+func TestHistoryStep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	m := newTestMeasurer(t, 2, time.Minute, clock)
+	fillPoints(m, clock, 3, 1, time.Second)
+	fillPoints(m, clock, 1, 2, time.Second)
+	m.RecordSent("CURRENT")
+
+	history := m.History(HistoryQuery{Step: 2 * time.Minute})
+	if len(history) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(history))
+	}
+	expected := []struct {
+		start     int64
+		sent      int
+		confirmed int
+		rate      float64
+		filled    bool
+	}{
+		{0, 4, 2, 0.5, true},
+		{120, 4, 3, 0.75, true},
+		{240, 1, 0, 0, false},
+	}
+	for i, e := range expected {
+		p := history[i]
+		if p.Start.Unix() != e.start || p.Sent != e.sent || p.Confirmed != e.confirmed || p.Rate != e.rate || p.Filled != e.filled {
+			t.Errorf("step %d: unexpected point %+v", i, p)
+		}
+	}
+
+	// steps are aligned to the start of the query
+	history = m.History(HistoryQuery{From: time.Unix(60, 0), Step: 2 * time.Minute})
+	if len(history) != 2 || history[0].Start.Unix() != 60 || history[0].Sent != 4 || history[1].Sent != 3 {
+		t.Fatalf("unexpected steps aligned to the query: %+v", history)
+	}
+
+	history = m.History(HistoryQuery{From: time.Unix(60, 0), To: time.Unix(120, 0)})
+	if len(history) != 2 || history[0].Start.Unix() != 60 || history[1].Start.Unix() != 120 {
+		t.Fatalf("unexpected points within the range: %+v", history)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	z := 1.959964
+	tests := []struct {
+		bucket bucket
+		lower  float64
+		upper  float64
+	}{
+		{bucket{ok: true, size: 100, confirmed: 50}, 0.4, 0.6},
+		{bucket{ok: true, size: 10, confirmed: 10}, 0.72, 1},
+		{bucket{ok: true, size: 10, confirmed: 0}, 0, 0.28},
+		{bucket{ok: true, size: 0}, -1, -1},
+		{bucket{ok: false, size: 10, confirmed: 5}, -1, -1},
+	}
+	for _, test := range tests {
+		lower, upper := test.bucket.interval(z)
+		if lower != test.lower || upper != test.upper {
+			t.Errorf("%d of %d: expected [%v, %v], got [%v, %v]", int(test.bucket.confirmed), int(test.bucket.size),
+				test.lower, test.upper, lower, upper)
+		}
+	}
+
+	m := newTestMeasurer(t, 1, time.Minute, &fakeClock{})
+	if m.z < 1.95 || m.z > 1.97 {
+		t.Fatalf("expected the quantile of the default confidence to be ~1.96, got %v", m.z)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p        float64
+		expected float64
+	}{
+		{0, 1},
+		{0.5, 5},
+		{0.9, 9},
+		{0.99, 10},
+		{1, 10},
+	}
+	for _, test := range tests {
+		if v := percentile(sorted, test.p); v != test.expected {
+			t.Errorf("p%v: expected %v, got %v", test.p*100, test.expected, v)
+		}
+	}
+	if v := percentile([]float64{12.345}, 0.5); v != 12.34 {
+		t.Errorf("expected the single value floored to two decimals, got %v", v)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	b := bucket{ok: true, latencies: []float64{90, 10, 45, 30}}
+	latency := b.latency([]float64{30, 60, 120})
+	if latency.Count != 4 || latency.P50 != 30 || latency.P99 != 90 {
+		t.Fatalf("unexpected latency: %+v", latency)
+	}
+	expected := []int{2, 3, 4}
+	for i, hb := range latency.Histogram {
+		if hb.Count != expected[i] {
+			t.Errorf("bucket le %v: expected %d, got %d", hb.LE, expected[i], hb.Count)
+		}
+	}
+
+	empty := (&bucket{ok: true}).latency([]float64{30})
+	if empty.P50 != -1 || empty.P90 != -1 || empty.P99 != -1 || empty.Histogram != nil {
+		t.Fatalf("unexpected latency without confirmations: %+v", empty)
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Family("confbox_sent_total", "Sent transactions.", KindCounter)
+	w.Sample("confbox_sent_total", 42)
+	w.Family("confbox_conf_rate", `Conf. rate per "window" in \ minutes`+"\nof the probe.", KindGauge)
+	w.Sample("confbox_conf_rate", 0.75, Label{Name: "window", Value: "5"}, Label{Name: "node", Value: `https://"node"\a` + "\nb"})
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+
+	expected := `# HELP confbox_sent_total Sent transactions.
+# TYPE confbox_sent_total counter
+confbox_sent_total 42
+# HELP confbox_conf_rate Conf. rate per "window" in \\ minutes\nof the probe.
+# TYPE confbox_conf_rate gauge
+confbox_conf_rate{window="5",node="https://\"node\"\\a\nb"} 0.75
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{0, "0"},
+		{-1, "-1"},
+		{0.5, "0.5"},
+		{1e21, "1e+21"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+	}
+	for _, test := range tests {
+		if formatted := formatValue(test.value); formatted != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, formatted)
+		}
+	}
+}
@@ -1,10 +1,45 @@
 package models
 
+import "time"
+
 type ConfRate struct {
-	Avg5  float64 `json:"avg_5"`
-	Avg10 float64 `json:"avg_10"`
-	Avg15 float64 `json:"avg_15"`
-	Avg30 float64 `json:"avg_30"`
+	Avg5    float64      `json:"avg_5"`
+	Avg10   float64      `json:"avg_10"`
+	Avg15   float64      `json:"avg_15"`
+	Avg30   float64      `json:"avg_30"`
+	Windows []WindowRate `json:"windows"`
+	// The windows backing Avg5, Avg10, Avg15 and Avg30, regardless of the configured windows.
+	LegacyWindows []WindowRate `json:"legacy_windows"`
+}
+
+// WindowRate is the avg. confirmation rate over a window of the given length in minutes.
+// Samples and Confirmed are the amount of sent and confirmed transactions within the window,
+// Lower and Upper the bounds of the confidence interval of the rate. The rate and bounds
+// are -1 if not enough points are filled yet.
+type WindowRate struct {
+	Window    int     `json:"window"`
+	Rate      float64 `json:"rate"`
+	Samples   int     `json:"samples"`
+	Confirmed int     `json:"confirmed"`
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
+	Latency   Latency `json:"latency"`
+}
+
+// Latency describes how long (seconds) the confirmed transactions of a window took to confirm.
+// The percentiles are -1 if no transaction confirmed yet within the window.
+type Latency struct {
+	P50       float64         `json:"p50"`
+	P90       float64         `json:"p90"`
+	P99       float64         `json:"p99"`
+	Count     int             `json:"count"`
+	Histogram []LatencyBucket `json:"histogram"`
+}
+
+// LatencyBucket holds the amount of transactions which confirmed within LE seconds.
+type LatencyBucket struct {
+	LE    float64 `json:"le"`
+	Count int     `json:"count"`
 }
 
 type Response struct {
@@ -12,6 +47,146 @@ type Response struct {
 	Config  ExposedConfig `json:"config"`
 }
 
+// HistoryResponse holds the retained measurement points from the oldest to the newest one.
+type HistoryResponse struct {
+	Points []Point `json:"points"`
+}
+
+// Point is the measurement data of a single point or of multiple points aggregated into one step.
+type Point struct {
+	Start        time.Time          `json:"start"`
+	Sent         int                `json:"sent"`
+	Confirmed    int                `json:"confirmed"`
+	Rate         float64            `json:"rate"`
+	Filled       bool               `json:"filled"`
+	Transactions []PointTransaction `json:"transactions,omitempty"`
+}
+
+// PointTransaction is a transaction sent off as part of a point.
+type PointTransaction struct {
+	Hash        string     `json:"hash"`
+	SentAt      time.Time  `json:"sent_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// TransactionEvent is streamed when a probe transaction is sent off or got confirmed.
+type TransactionEvent struct {
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// health statuses
+const (
+	HealthStatusOk          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// HealthResponse describes the state of the sender, the quorum and the windows of a ConfBox.
+type HealthResponse struct {
+	Status  string         `json:"status"`
+	Sender  SenderHealth   `json:"sender"`
+	Quorum  QuorumHealth   `json:"quorum"`
+	Windows []WindowHealth `json:"windows"`
+}
+
+// SenderHealth tells whether the sender recently sent off a transaction successfully.
+type SenderHealth struct {
+	Ok          bool       `json:"ok"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// QuorumHealth tells whether the quorum reaches its nodes and stays within the max subtangle milestone delta.
+type QuorumHealth struct {
+	Ok                         bool       `json:"ok"`
+	LastCall                   *time.Time `json:"last_call,omitempty"`
+	LastSubtangleQuery         *time.Time `json:"last_subtangle_query,omitempty"`
+	SubtangleMilestoneDelta    uint64     `json:"subtangle_milestone_delta"`
+	MaxSubtangleMilestoneDelta uint64     `json:"max_subtangle_milestone_delta"`
+	LaggingNodes               uint64     `json:"lagging_nodes"`
+	Error                      string     `json:"error,omitempty"`
+}
+
+// NodesResponse describes the health of each node used by the quorum.
+type NodesResponse struct {
+	Nodes []NodeHealth `json:"nodes"`
+}
+
+// NodeHealth describes the health and circuit state of a single quorum node.
+// Latencies are in milliseconds.
+type NodeHealth struct {
+	URL                     string      `json:"url"`
+	State                   string      `json:"state"`
+	Successes               uint64      `json:"successes"`
+	Failures                uint64      `json:"failures"`
+	ConsecutiveFailures     int         `json:"consecutive_failures"`
+	LastLatency             float64     `json:"last_latency"`
+	AvgLatency              float64     `json:"avg_latency"`
+	LastSuccess             *time.Time  `json:"last_success,omitempty"`
+	OpenedAt                *time.Time  `json:"opened_at,omitempty"`
+	QuarantinedUntil        *time.Time  `json:"quarantined_until,omitempty"`
+	Lagging                 bool        `json:"lagging"`
+	SubtangleMilestoneIndex uint64      `json:"subtangle_milestone_index"`
+	InFlight                int         `json:"in_flight"`
+	Errors                  []NodeError `json:"errors"`
+}
+
+// NodeError is a recent error of a quorum node.
+type NodeError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// QuorumReportsResponse holds the most recent reports of quorum calls, newest first.
+type QuorumReportsResponse struct {
+	Reports []QuorumReport `json:"reports"`
+}
+
+// QuorumReport describes how the result of a quorum call was decided.
+// Durations and latencies are in milliseconds.
+type QuorumReport struct {
+	Command          string        `json:"command"`
+	Time             time.Time     `json:"time"`
+	Duration         float64       `json:"duration"`
+	Percentage       float64       `json:"percentage"`
+	Selected         int           `json:"selected"`
+	EarlyTermination bool          `json:"early_termination"`
+	ElementQuorum    bool          `json:"element_quorum"`
+	DefaultsInjected bool          `json:"defaults_injected"`
+	WriteQuorum      bool          `json:"write_quorum"`
+	Error            string        `json:"error,omitempty"`
+	Agreed           []string      `json:"agreed"`
+	Dissented        []string      `json:"dissented"`
+	Rejected         []string      `json:"rejected"`
+	Pending          []string      `json:"pending"`
+	Groups           []VoteGroup   `json:"groups"`
+	Nodes            []NodeOutcome `json:"nodes"`
+}
+
+// VoteGroup is a group of nodes which gave the same response in a quorum call.
+type VoteGroup struct {
+	Votes      float64  `json:"votes"`
+	StatusCode int      `json:"status_code"`
+	Nodes      []string `json:"nodes"`
+}
+
+// NodeOutcome describes the outcome of a quorum call on a single node.
+// Group is the index of the group the node voted for, -1 if it didn't vote.
+type NodeOutcome struct {
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code"`
+	Latency    float64 `json:"latency"`
+	Group      int     `json:"group"`
+	Pending    bool    `json:"pending"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// WindowHealth tells whether enough points are filled to compute the avg. conf. rate of a window.
+type WindowHealth struct {
+	Window int  `json:"window"`
+	Filled bool `json:"filled"`
+}
+
 type ExposedConfig struct {
 	MWM             uint64 `json:"mwm"`
 	GTTADepth       uint64 `json:"gtta_depth"`
@@ -22,4 +197,15 @@ type ExposedConfig struct {
 		Enabled  bool   `json:"enabled"`
 		Interval uint64 `json:"interval"`
 	} `json:"promote_reattach"`
+	Probe ProbeConfig `json:"probe"`
+}
+
+// ProbeConfig defines how the probe transactions are sent off.
+type ProbeConfig struct {
+	// amount of transactions per point
+	BatchSize uint64 `json:"batch_size"`
+	// interval (seconds) in which points are filled
+	PointInterval uint64 `json:"point_interval"`
+	// how often sending a transaction is retried before it is skipped
+	MaxRetries *uint64 `json:"max_retries"`
 }
@@ -13,6 +13,11 @@ import (
 
 // ErrNonOkHttpStatusCode is returned when the connected ConfBox sends a non ok HTTP status code.
 var ErrNonOkHttpStatusCode = errors.New("non ok http status code from ConfBox")
+
+// ErrWindowNotAvailable is returned when the connected ConfBox doesn't report the interval of the window
+// of the selected avg. mode, i.e. as it predates the confidence intervals.
+var ErrWindowNotAvailable = errors.New("connected ConfBox doesn't compute the window of the selected avg. mode")
+
 // ErrConfBoxNotReady is returned when the connected ConfBox did not yet compute the conf. rate for
 // the selected avg. mode.
 var ErrConfBoxNotReady = errors.New("connected ConfBox isn't ready yet")
@@ -28,15 +33,33 @@ const (
 	AvgMode30Min
 )
 
+// The estimate of the conf. rate to compare against the threshold.
+type Estimate byte
+
+// available estimates.
+const (
+	// use the avg. conf. rate itself.
+	EstimatePoint Estimate = iota
+	// use the lower bound of the confidence interval of the avg. conf. rate,
+	// which accounts for the amount of transactions the rate is based on.
+	EstimateLowerBound
+)
+
 // DefaultConfBoxDecider creates a new ConfBoxDecider with an average confirmation rate threshold
 // of 65% in the last 10 minutes.
 func DefaultConfBoxDecider(confBoxURL string, timeSource timesrc.TimeSource) *ConfBoxDecider {
-	return &ConfBoxDecider{confBoxURL, timeSource, 0.65, AvgMode10Min}
+	return &ConfBoxDecider{confBoxURL, timeSource, 0.65, AvgMode10Min, EstimatePoint}
 }
 
 // NewConfBoxDecider creates a new ConfBox decider with the given threshold and mode.
 func NewConfBoxDecider(confBoxURL string, timeSource timesrc.TimeSource, threshold float64, mode AvgMode) *ConfBoxDecider {
-	return &ConfBoxDecider{confBoxURL, timeSource, threshold, mode}
+	return &ConfBoxDecider{confBoxURL, timeSource, threshold, mode, EstimatePoint}
+}
+
+// NewConfBoxDeciderWithEstimate creates a new ConfBox decider with the given threshold and mode
+// which compares the given estimate of the conf. rate against the threshold.
+func NewConfBoxDeciderWithEstimate(confBoxURL string, timeSource timesrc.TimeSource, threshold float64, mode AvgMode, estimate Estimate) *ConfBoxDecider {
+	return &ConfBoxDecider{confBoxURL, timeSource, threshold, mode, estimate}
 }
 
 // ConfBoxDecider is an OracleSource which given the current average confirmation rate
@@ -49,11 +72,13 @@ type ConfBoxDecider struct {
 	timeSource timesrc.TimeSource
 	threshold  float64
 	mode       AvgMode
+	estimate   Estimate
 }
 
 const dateFormat = "2006-02-01 15:04:05"
 const notAvailMsg = "avg. conf. rate %d min not available yet"
 const belowThreshold = "current conf. rate of %.2f (avg. %d min) is below set threshold of %.2f"
+const lowerBoundBelowThreshold = "lower bound %.2f of current conf. rate of %.2f (avg. %d min, %d/%d txs confirmed) is below set threshold of %.2f"
 const timeDeltaBelowAvg = "time delta between now (%s) and the CDR timeout (%s) is below the selected avg. conf. rate mode (%d min). (delta %s)"
 
 var modeToMinMap = map[AvgMode]int{
@@ -92,7 +117,7 @@ func (cbd *ConfBoxDecider) Ok(conds *deposit.CDA) (bool, string, error) {
 	timeDelta := timeout.Sub(now)
 
 	var currentConfRate float64
-	switch (cbd.mode) {
+	switch cbd.mode {
 	case AvgMode5Min:
 		currentConfRate = confBoxRes.Results.Avg5
 	case AvgMode10Min:
@@ -106,7 +131,20 @@ func (cbd *ConfBoxDecider) Ok(conds *deposit.CDA) (bool, string, error) {
 	if currentConfRate == -1 {
 		return false, "", errors.Wrap(ErrConfBoxNotReady, fmt.Sprintf(notAvailMsg, modeToMinMap[cbd.mode]))
 	}
-	if currentConfRate < cbd.threshold {
+
+	if cbd.estimate == EstimateLowerBound {
+		// the legacy windows are reported regardless of the windows configured on the ConfBox
+		window := findWindow(confBoxRes.Results.Windows, modeToMinMap[cbd.mode])
+		if window == nil {
+			window = findWindow(confBoxRes.Results.LegacyWindows, modeToMinMap[cbd.mode])
+		}
+		if window == nil {
+			return false, "", errors.Wrapf(ErrWindowNotAvailable, "%d min", modeToMinMap[cbd.mode])
+		}
+		if window.Lower < cbd.threshold {
+			return false, fmt.Sprintf(lowerBoundBelowThreshold, window.Lower, window.Rate, modeToMinMap[cbd.mode], window.Confirmed, window.Samples, cbd.threshold), nil
+		}
+	} else if currentConfRate < cbd.threshold {
 		return false, fmt.Sprintf(belowThreshold, currentConfRate, modeToMinMap[cbd.mode], cbd.threshold), nil
 	}
 	if timeDelta.Minutes() < float64(modeToMinMap[cbd.mode]) {
@@ -115,3 +153,13 @@ func (cbd *ConfBoxDecider) Ok(conds *deposit.CDA) (bool, string, error) {
 
 	return true, "", nil
 }
+
+// returns the rate of the given window (minutes), nil if it isn't part of the given windows.
+func findWindow(windows []models.WindowRate, window int) *models.WindowRate {
+	for i := range windows {
+		if windows[i].Window == window {
+			return &windows[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package quorum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuorumError is returned when a quorum call fails because too many nodes didn't respond,
+// no quorum was reached or the nodes exceeded the max subtangle milestone delta.
+// It carries the outcome of the call on each node and unwraps to the corresponding
+// error of the package (i.e. ErrQuorumNotReached), which is also returned by errors.Cause.
+type QuorumError struct {
+	// The error of the package describing why the call failed.
+	Err error
+	// What exactly went wrong.
+	Message string
+	// The outcome of the call on each queried node.
+	Nodes []NodeOutcome
+	// The groups of equal responses, ordered by their votes descending.
+	Groups []VoteGroup
+}
+
+// NodeOutcome describes the outcome of a quorum call on a single node.
+type NodeOutcome struct {
+	// The URL of the node.
+	URL string
+	// The error which occurred when calling the node, nil if the node gave a valid response.
+	Err error
+	// The status code of the node's response, 0 if the node didn't respond.
+	StatusCode int
+	// The time it took the node to respond.
+	Latency time.Duration
+	// The index of the group the node voted for, -1 if the node didn't vote.
+	Group int
+	// Whether the node didn't respond yet when the call returned.
+	Pending bool
+	hash    uint64
+}
+
+// VoteGroup is a group of nodes which gave the same response.
+type VoteGroup struct {
+	// The weighted votes of the group.
+	Votes float64
+	// The status code of the group's response.
+	StatusCode int
+	// The URLs of the nodes which gave the response.
+	Nodes []string
+}
+
+func (e *QuorumError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	details := make([]string, 0, len(e.Groups)+len(e.Nodes))
+	for i, group := range e.Groups {
+		details = append(details, fmt.Sprintf("group %d (%0.2f votes, status %d): %s", i, group.Votes, group.StatusCode, strings.Join(group.Nodes, ", ")))
+	}
+	for _, node := range e.Nodes {
+		if node.Err != nil {
+			details = append(details, fmt.Sprintf("%s failed: %v", node.URL, node.Err))
+		}
+		if node.Pending {
+			details = append(details, fmt.Sprintf("%s pending", node.URL))
+		}
+	}
+	if len(details) > 0 {
+		b.WriteString(" [")
+		b.WriteString(strings.Join(details, "; "))
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// Cause returns the error of the package describing why the call failed.
+func (e *QuorumError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the error of the package describing why the call failed.
+func (e *QuorumError) Unwrap() error {
+	return e.Err
+}
+
+// returns the given outcomes followed by an outcome for each of the given pending nodes.
+func withPending(outcomes []NodeOutcome, pending map[string]struct{}) []NodeOutcome {
+	if len(pending) == 0 {
+		return outcomes
+	}
+	all := make([]NodeOutcome, len(outcomes), len(outcomes)+len(pending))
+	copy(all, outcomes)
+	for node := range pending {
+		all = append(all, NodeOutcome{URL: node, Group: -1, Pending: true})
+	}
+	sort.Slice(all[len(outcomes):], func(i, j int) bool {
+		return all[len(outcomes)+i].URL < all[len(outcomes)+j].URL
+	})
+	return all
+}
+
+// creates a new QuorumError from the given outcomes and groups the nodes by their votes.
+func newQuorumError(err error, outcomes []NodeOutcome, quorumCheck *quorumcheck, format string, args ...interface{}) *QuorumError {
+	qErr := &QuorumError{Err: err, Message: fmt.Sprintf(format, args...)}
+	qErr.Nodes, qErr.Groups, _ = groupVotes(outcomes, quorumCheck)
+	return qErr
+}
+
+// groups the nodes of the given outcomes by the responses they voted for.
+// the groups are ordered by their votes descending. returns a copy of the outcomes in which
+// the group of each node which voted refers to the index of its group, the groups and the index
+// of the group of each response hash.
+func groupVotes(outcomes []NodeOutcome, quorumCheck *quorumcheck) ([]NodeOutcome, []VoteGroup, map[uint64]int) {
+	nodes := make([]NodeOutcome, len(outcomes))
+	copy(nodes, outcomes)
+	if quorumCheck == nil {
+		return nodes, nil, nil
+	}
+
+	hashes := make([]uint64, 0, len(quorumCheck.votes))
+	for hash := range quorumCheck.votes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return quorumCheck.votes[hashes[i]].votes > quorumCheck.votes[hashes[j]].votes
+	})
+	groups := make([]VoteGroup, len(hashes))
+	groupIndex := make(map[uint64]int, len(hashes))
+	for i, hash := range hashes {
+		groupIndex[hash] = i
+		vote := quorumCheck.votes[hash]
+		groups[i] = VoteGroup{Votes: vote.votes, StatusCode: vote.status}
+	}
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Group == -1 {
+			continue
+		}
+		node.Group = groupIndex[node.hash]
+		groups[node.Group].Nodes = append(groups[node.Group].Nodes, node.URL)
+	}
+	return nodes, groups, groupIndex
+}
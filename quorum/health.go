@@ -0,0 +1,331 @@
+package quorum
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of a node.
+type CircuitState string
+
+// circuit states
+const (
+	// the node is queried normally.
+	CircuitClosed CircuitState = "closed"
+	// the node failed too often and is excluded from calls.
+	CircuitOpen CircuitState = "open"
+	// the node was excluded long enough and a single probing call is let through
+	// to determine whether the circuit can be closed again.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreakerSettings defines when the circuit of a failing node is opened
+// and for how long it stays open before the node is probed again.
+type CircuitBreakerSettings struct {
+	// The amount of consecutive failures after which the circuit of a node is opened.
+	// Defaults to DefaultCircuitFailureThreshold.
+	FailureThreshold int
+	// For how long an opened circuit excludes the node before a probing call is let through.
+	// Defaults to DefaultCircuitOpenDuration.
+	OpenDuration time.Duration
+}
+
+// circuit breaker defaults
+const (
+	DefaultCircuitFailureThreshold = 3
+	DefaultCircuitOpenDuration     = time.Duration(1) * time.Minute
+)
+
+// DefaultQuarantineDuration is the default duration for which nodes on a fork are excluded from calls.
+const DefaultQuarantineDuration = time.Duration(10) * time.Minute
+
+// DefaultFailoverBackoff is the default duration for which a failed node is skipped
+// for calls without quorum if no circuit breaker is configured.
+const DefaultFailoverBackoff = time.Duration(30) * time.Second
+
+// the amount of errors kept per node.
+const nodeErrorHistorySize = 10
+
+// the weight of a new latency measurement in the moving average latency of a node.
+const latencyEWMAWeight = 0.2
+
+// NodeError is an error which occurred when calling a node.
+type NodeError struct {
+	Time  time.Time
+	Error string
+}
+
+// NodeHealth describes the health of a node as observed by the quorum client.
+type NodeHealth struct {
+	URL                 string
+	State               CircuitState
+	Successes           uint64
+	Failures            uint64
+	ConsecutiveFailures int
+	LastLatency         time.Duration
+	AvgLatency          time.Duration
+	LastSuccess         time.Time
+	OpenedAt            time.Time
+	QuarantinedUntil    time.Time
+	// Whether the node's latest solid subtangle milestone lags behind the other nodes
+	// by more than the max subtangle milestone delta, and the index of that milestone.
+	Lagging                 bool
+	SubtangleMilestoneIndex uint64
+	InFlight                int
+	Errors                  []NodeError
+}
+
+type nodestate struct {
+	health  NodeHealth
+	probing bool
+}
+
+// nodetracker keeps track of the health of each node and
+// manages their circuits if a circuit breaker is configured.
+type nodetracker struct {
+	mu                 sync.Mutex
+	nodes              map[string]*nodestate
+	order              []string
+	breaker            *CircuitBreakerSettings
+	quarantineDuration time.Duration
+}
+
+func newNodeTracker(nodes []string, breaker *CircuitBreakerSettings, quarantineDuration time.Duration) *nodetracker {
+	t := &nodetracker{nodes: map[string]*nodestate{}, quarantineDuration: quarantineDuration}
+	if breaker != nil {
+		settings := *breaker
+		if settings.FailureThreshold <= 0 {
+			settings.FailureThreshold = DefaultCircuitFailureThreshold
+		}
+		if settings.OpenDuration <= 0 {
+			settings.OpenDuration = DefaultCircuitOpenDuration
+		}
+		t.breaker = &settings
+	}
+	for _, node := range nodes {
+		t.add(node)
+	}
+	return t
+}
+
+// adds the given node to the tracker if it isn't tracked yet.
+func (t *nodetracker) add(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, has := t.nodes[node]; has {
+		return
+	}
+	t.nodes[node] = &nodestate{health: NodeHealth{URL: node, State: CircuitClosed}}
+	t.order = append(t.order, node)
+}
+
+// tells whether the given node may be called. quarantined nodes are never called. an open circuit
+// transitions into half-open once the open duration passed, after which a single probing call is allowed.
+func (t *nodetracker) allow(node string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has {
+		return true
+	}
+	if time.Now().Before(state.health.QuarantinedUntil) {
+		return false
+	}
+	if t.breaker == nil {
+		return true
+	}
+	switch state.health.State {
+	case CircuitOpen:
+		if time.Since(state.health.OpenedAt) < t.breaker.OpenDuration {
+			return false
+		}
+		state.health.State = CircuitHalfOpen
+		state.probing = true
+		return true
+	case CircuitHalfOpen:
+		if state.probing {
+			return false
+		}
+		state.probing = true
+		return true
+	}
+	return true
+}
+
+// returns the nodes which may be called, excluding lagging nodes if requested. if no node may be called,
+// the nodes which aren't quarantined are returned as excluding all of them would make every call fail.
+// nodes on a fork are never called, so no node is returned if all nodes are quarantined.
+func (t *nodetracker) allowed(nodes []string, excludeLagging bool) []string {
+	selected := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if excludeLagging && t.lagging(node) {
+			continue
+		}
+		if t.allow(node) {
+			selected = append(selected, node)
+		}
+	}
+	if len(selected) > 0 {
+		return selected
+	}
+	for _, node := range nodes {
+		if !t.quarantined(node) {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// records a successful call to the given node and closes its circuit.
+func (t *nodetracker) success(node string, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has {
+		return
+	}
+	h := &state.health
+	h.Successes++
+	h.ConsecutiveFailures = 0
+	h.LastSuccess = time.Now()
+	h.LastLatency = latency
+	if h.AvgLatency == 0 {
+		h.AvgLatency = latency
+	} else {
+		h.AvgLatency = time.Duration(latencyEWMAWeight*float64(latency) + (1-latencyEWMAWeight)*float64(h.AvgLatency))
+	}
+	h.State = CircuitClosed
+	state.probing = false
+}
+
+// records a failed call to the given node and opens its circuit if it failed too often
+// or if the probing call of a half-open circuit failed.
+func (t *nodetracker) failure(node string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has {
+		return
+	}
+	h := &state.health
+	h.Failures++
+	h.ConsecutiveFailures++
+	appendError(h, err)
+	if t.breaker == nil {
+		return
+	}
+	if h.State == CircuitHalfOpen || h.ConsecutiveFailures >= t.breaker.FailureThreshold {
+		h.State = CircuitOpen
+		h.OpenedAt = time.Now()
+	}
+	state.probing = false
+}
+
+// tells whether the last call to the given node failed within the given duration.
+func (t *nodetracker) failedRecently(node string, within time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has || state.health.ConsecutiveFailures == 0 || len(state.health.Errors) == 0 {
+		return false
+	}
+	return time.Since(state.health.Errors[len(state.health.Errors)-1].Time) < within
+}
+
+// releases the probing call of a half-open circuit of the given node
+// if the call was cancelled before the node responded.
+func (t *nodetracker) abort(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, has := t.nodes[node]; has {
+		state.probing = false
+	}
+}
+
+// tells whether the given node lags behind the other nodes.
+func (t *nodetracker) lagging(node string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	return has && state.health.Lagging
+}
+
+// records the latest solid subtangle milestone index of the given node and whether it lags behind.
+func (t *nodetracker) synced(node string, index uint64, lagging bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, has := t.nodes[node]; has {
+		state.health.SubtangleMilestoneIndex = index
+		state.health.Lagging = lagging
+	}
+}
+
+// tells whether the given node is excluded from calls as it was on a fork.
+func (t *nodetracker) quarantined(node string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	return has && time.Now().Before(state.health.QuarantinedUntil)
+}
+
+// excludes the given node from calls for the quarantine duration.
+func (t *nodetracker) quarantine(node string, reason error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has {
+		return
+	}
+	h := &state.health
+	h.QuarantinedUntil = time.Now().Add(t.quarantineDuration)
+	appendError(h, reason)
+}
+
+// adds the given error to the error history of the node, dropping the oldest error if the history is full.
+func appendError(h *NodeHealth, err error) {
+	h.Errors = append(h.Errors, NodeError{Time: time.Now(), Error: err.Error()})
+	if len(h.Errors) > nodeErrorHistorySize {
+		h.Errors = h.Errors[len(h.Errors)-nodeErrorHistorySize:]
+	}
+}
+
+// marks the start of a request to the given node.
+func (t *nodetracker) begin(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, has := t.nodes[node]; has {
+		state.health.InFlight++
+	}
+}
+
+// marks the end of a request to the given node.
+func (t *nodetracker) end(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, has := t.nodes[node]; has {
+		state.health.InFlight--
+	}
+}
+
+// returns the moving average latency and the amount of requests in flight of the given node.
+func (t *nodetracker) load(node string) (time.Duration, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, has := t.nodes[node]
+	if !has {
+		return 0, 0
+	}
+	return state.health.AvgLatency, state.health.InFlight
+}
+
+// returns a snapshot of the health of all tracked nodes.
+func (t *nodetracker) snapshot() []NodeHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	health := make([]NodeHealth, len(t.order))
+	for i, node := range t.order {
+		health[i] = t.nodes[node].health
+		health[i].Errors = append([]NodeError{}, health[i].Errors...)
+	}
+	return health
+}
@@ -0,0 +1,173 @@
+package quorum
+
+import (
+	"context"
+	"github.com/iotaledger/iota.go/api"
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAllowedFallbackExcludesQuarantinedNodes(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	tracker := newNodeTracker(nodes, &CircuitBreakerSettings{FailureThreshold: 1, OpenDuration: time.Minute}, time.Minute)
+	fork := errors.New("on a fork")
+	tracker.quarantine("a", fork)
+	tracker.quarantine("b", fork)
+	tracker.failure("c", errors.New("connection refused"))
+	tracker.synced("d", 1, true)
+
+	if allowed := tracker.allowed(nodes, false); !reflect.DeepEqual(allowed, []string{"d"}) {
+		t.Fatalf("expected only d to be allowed, got %v", allowed)
+	}
+	// neither the lagging nor the open node is allowed, so both are used as fallback
+	if allowed := tracker.allowed(nodes, true); !reflect.DeepEqual(allowed, []string{"c", "d"}) {
+		t.Fatalf("expected the lagging and open nodes as fallback, got %v", allowed)
+	}
+
+	tracker.quarantine("c", fork)
+	tracker.quarantine("d", fork)
+	if allowed := tracker.allowed(nodes, true); len(allowed) != 0 {
+		t.Fatalf("quarantined nodes must not be used as fallback, got %v", allowed)
+	}
+}
+
+func TestAllNodesQuarantined(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: balancesResponse},
+		"b": {status: 200, body: balancesResponse},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{})
+	tracker := provider.(*quorumhttpclient).tracker
+	for _, node := range transport.nodes() {
+		tracker.quarantine(node, errors.New("on a fork"))
+	}
+
+	if _, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{}); errors.Cause(err) != ErrAllNodesQuarantined {
+		t.Fatalf("expected ErrAllNodesQuarantined for a quorum call, got %v", err)
+	}
+	cmd := &api.GetTransactionsToApproveCommand{Command: api.Command{Command: api.GetTransactionsToApproveCmd}, Depth: 3}
+	if _, err := provider.SendWithReport(context.Background(), cmd, &api.GetTransactionsToApproveResponse{}); errors.Cause(err) != ErrAllNodesQuarantined {
+		t.Fatalf("expected ErrAllNodesQuarantined for a failover call, got %v", err)
+	}
+}
+
+func newTestBreaker(node string) *nodetracker {
+	return newNodeTracker([]string{node}, &CircuitBreakerSettings{FailureThreshold: 3, OpenDuration: time.Minute}, time.Minute)
+}
+
+// moves the time the circuit of the given node opened back by the given duration.
+func openedAgo(tracker *nodetracker, node string, d time.Duration) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.nodes[node].health.OpenedAt = time.Now().Add(-d)
+}
+
+func circuitState(tracker *nodetracker, node string) CircuitState {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.nodes[node].health.State
+}
+
+func TestCircuitOpensAfterFailureThreshold(t *testing.T) {
+	tracker := newTestBreaker("a")
+	for i := 0; i < 2; i++ {
+		tracker.failure("a", errors.New("connection refused"))
+	}
+	if state := circuitState(tracker, "a"); state != CircuitClosed || !tracker.allow("a") {
+		t.Fatalf("expected the circuit to stay closed below the failure threshold, got %s", state)
+	}
+
+	// a success resets the consecutive failures
+	tracker.success("a", time.Millisecond)
+	for i := 0; i < 2; i++ {
+		tracker.failure("a", errors.New("connection refused"))
+	}
+	if state := circuitState(tracker, "a"); state != CircuitClosed {
+		t.Fatalf("expected the circuit to stay closed after a success, got %s", state)
+	}
+
+	tracker.failure("a", errors.New("connection refused"))
+	if state := circuitState(tracker, "a"); state != CircuitOpen || tracker.allow("a") {
+		t.Fatalf("expected the circuit to open at the failure threshold, got %s", state)
+	}
+}
+
+func TestCircuitHalfOpenProbe(t *testing.T) {
+	tracker := newTestBreaker("a")
+	for i := 0; i < 3; i++ {
+		tracker.failure("a", errors.New("connection refused"))
+	}
+	openedAgo(tracker, "a", 30*time.Second)
+	if tracker.allow("a") {
+		t.Fatal("the node must not be called before the open duration passed")
+	}
+
+	// a single probe is allowed once the open duration passed
+	openedAgo(tracker, "a", time.Minute)
+	if !tracker.allow("a") {
+		t.Fatal("expected a probe to be allowed after the open duration")
+	}
+	if state := circuitState(tracker, "a"); state != CircuitHalfOpen {
+		t.Fatalf("expected the circuit to be half-open, got %s", state)
+	}
+	if tracker.allow("a") {
+		t.Fatal("only a single probe may be in flight")
+	}
+
+	// an aborted probe releases the circuit for the next probe
+	tracker.abort("a")
+	if !tracker.allow("a") {
+		t.Fatal("expected a probe to be allowed after the previous one was aborted")
+	}
+
+	// a failed probe opens the circuit again right away
+	tracker.failure("a", errors.New("connection refused"))
+	if state := circuitState(tracker, "a"); state != CircuitOpen || tracker.allow("a") {
+		t.Fatalf("expected the circuit to open after a failed probe, got %s", state)
+	}
+
+	// a successful probe closes the circuit
+	openedAgo(tracker, "a", time.Minute)
+	if !tracker.allow("a") {
+		t.Fatal("expected a probe to be allowed after the open duration")
+	}
+	tracker.success("a", time.Millisecond)
+	if state := circuitState(tracker, "a"); state != CircuitClosed || !tracker.allow("a") || !tracker.allow("a") {
+		t.Fatalf("expected the circuit to close after a successful probe, got %s", state)
+	}
+}
+
+func TestOpenNodesLeaveTheDenominator(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: balancesResponse},
+		"b": {status: 200, body: balancesResponse},
+		"c": {status: 200, body: balancesResponse},
+		"d": {err: errors.New("connection refused")},
+	}
+	tolerance := 0.0
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		Threshold:      0.8,
+		CircuitBreaker: &CircuitBreakerSettings{FailureThreshold: 1, OpenDuration: time.Minute},
+		Policies:       map[api.IRICommand]CommandPolicy{api.GetBalancesCmd: {NoResponseTolerance: &tolerance}},
+	})
+
+	// d failing to respond exceeds the no-response tolerance and opens its circuit
+	if _, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{}); errors.Cause(err) != ErrExceededNoResponseTolerance {
+		t.Fatalf("expected ErrExceededNoResponseTolerance, got %v", err)
+	}
+
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached without the open node, got %v", err)
+	}
+	if len(report.Nodes) != 3 || report.Percentage != 1 {
+		t.Fatalf("expected d to be neither queried nor counted, got %+v", report)
+	}
+	for _, node := range report.Nodes {
+		if node.URL == "d" {
+			t.Fatalf("the open node must not be queried: %+v", report.Nodes)
+		}
+	}
+}
@@ -2,20 +2,24 @@ package quorum
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/cespare/xxhash"
-	. "github.com/iotaledger/iota.go/api"
+	"github.com/iotaledger/iota.go/api"
 	. "github.com/iotaledger/iota.go/consts"
 	"github.com/iotaledger/iota.go/pow"
 	"github.com/iotaledger/iota.go/trinary"
 	"github.com/pkg/errors"
-	"io/ioutil"
 	"math"
-	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // QuorumLevel defines the percentage needed for a quorum.
@@ -37,14 +41,26 @@ var (
 	ErrNoLatestSolidSubtangleInfo             = errors.New("no latest solid subtangle info found")
 	ErrExceededMaxSubtangleMilestoneDelta     = errors.New("exceeded max subtangle milestone delta between nodes")
 	ErrNonOkStatusCodeSubtangleMilestoneQuery = errors.New("non ok status code for subtangle milestone query")
+	ErrInvalidNodeWeight                      = errors.New("node weights must be >0 and belong to a defined node")
+	ErrUnknownSelectionStrategy               = errors.New("unknown selection strategy")
+	ErrConflictingSubtangleMilestones         = errors.New("nodes report different subtangle milestones for the same index")
+	ErrNotEnoughSyncedNodes                   = errors.New("not enough nodes are within the max subtangle milestone delta")
+	ErrInvalidNoResponseTolerance             = errors.New("no-response tolerance must be within [0,1]")
+	ErrInvalidWriteQuorum                     = errors.New("min. accepted nodes must be >=0 and min. accepted share within [0,1]")
+	ErrWriteQuorumNotReached                  = errors.New("not enough nodes accepted the write")
+	ErrAllNodesQuarantined                    = errors.New("all nodes are quarantined")
 )
 
+// DefaultMinSyncedNodes is the default minimum amount of nodes which must
+// be within the max subtangle milestone delta when lagging nodes are excluded.
+const DefaultMinSyncedNodes = 2
+
 // MinimumQuorumThreshold is the minimum threshold the quorum settings
 // must have.
 const MinimumQuorumThreshold = 0.5
 
 // NewQuorumHTTPClient creates a new quorum based Http Provider.
-func NewQuorumHTTPClient(settings interface{}) (Provider, error) {
+func NewQuorumHTTPClient(settings interface{}) (api.Provider, error) {
 	client := &quorumhttpclient{}
 	if err := client.SetSettings(settings); err != nil {
 		return nil, err
@@ -59,6 +75,38 @@ type QuorumDefaults struct {
 	GetBalances            *uint64
 }
 
+// CommandPolicy defines how the quorum is formed for a specific command.
+type CommandPolicy struct {
+	// Overrides the Threshold of the settings for the command, must be >0.5.
+	Threshold *float64
+	// Overrides the NoResponseTolerance of the settings for the command.
+	NoResponseTolerance *float64
+	// Accepts the first response with an ok status code without forming a quorum,
+	// i.e. for commands whose responses can be verified by the caller such as GetTrytes.
+	AcceptAny bool
+	// Treats the command as a write which succeeds once at least MinAccepted nodes
+	// and MinAcceptedShare of the queried nodes responded with an ok status code,
+	// regardless of whether their responses are equal. Meant for commands in
+	// ForceQuorumSend such as BroadcastTransactions and StoreTransactions.
+	// The command is voted on as usual if neither is set.
+	MinAccepted      int
+	MinAcceptedShare float64
+}
+
+// tells whether the policy treats its command as a write.
+func (p CommandPolicy) write() bool {
+	return p.MinAccepted > 0 || p.MinAcceptedShare > 0
+}
+
+// returns the amount of nodes which must accept a write sent to the given amount of nodes.
+func (p CommandPolicy) minAccepted(queried int) int {
+	min := int(math.Ceil(p.MinAcceptedShare * float64(queried)))
+	if p.MinAccepted > min {
+		min = p.MinAccepted
+	}
+	return min
+}
+
 // QuorumHTTPClientSettings defines a set of settings for when constructing a new Http Provider.
 type QuorumHTTPClientSettings struct {
 	// The threshold/majority percentage which must be reached in the responses
@@ -75,17 +123,49 @@ type QuorumHTTPClientSettings struct {
 	// For certain commands for which a quorum doesn't make sense
 	// this node will be used. For example GetTransactionsToApprove
 	// would always fail when queried via a quorum.
-	// If no PrimaryNode is set, then a node is randomly selected from Nodes
+	// If no PrimaryNode is set, then a node is selected from Nodes using the Selection strategy
 	// for executing calls for which no quorum can be done.
 	// The primary node is not used for forming the quorum and must be
 	// explicitly set in the Nodes field a second time.
 	PrimaryNode *string
 
+	// The strategy used to select a node from Nodes for the commands for which no quorum
+	// can be done if no PrimaryNode is set or if the primary and failover nodes failed.
+	// Defaults to SelectRandom.
+	Selection SelectionStrategy
+
+	// Nodes which are used in the given order for the commands for which no quorum can be done
+	// when the PrimaryNode fails to give a response. If all of them fail too, the remaining nodes
+	// of Nodes are tried in the order of the Selection strategy. Nodes whose circuit is open are
+	// skipped, so that with a CircuitBreaker the primary node is only used again once a probing
+	// call succeeded. Without a CircuitBreaker, nodes whose last call failed are skipped for FailoverBackoff.
+	FailoverNodes []string
+
+	// For how long a node whose last call failed is skipped for the commands for which no quorum
+	// can be done if no CircuitBreaker is set. Defaults to DefaultFailoverBackoff.
+	FailoverBackoff time.Duration
+
 	// The nodes to which the client connects to.
 	Nodes []string
 
+	// Optional policies which override the Threshold and NoResponseTolerance for specific commands.
+	// Commands without a policy or fields not set in a policy fall back to the fields above.
+	Policies map[api.IRICommand]CommandPolicy
+
+	// Optional weights of the votes of the nodes, keyed by the node's URL as defined in Nodes.
+	// Nodes without a weight have a weight of 1. When weights are defined, the Threshold
+	// applies to the weighted share of the responses instead of the share of responding nodes.
+	// For example, a node with a weight of 2 counts as much as two nodes with a weight of 1.
+	NodeWeights map[string]float64
+
 	// The underlying HTTPClient to use. Defaults to http.DefaultClient.
-	Client HTTPClient
+	// Only used by the default transport if no Transport is set.
+	Client api.HTTPClient
+
+	// The transport used to execute commands on the nodes, for calls in quorum
+	// as well as for calls on the primary or a random node.
+	// Defaults to an HTTPTransport using Client.
+	Transport NodeTransport
 
 	// The Proof-of-Work implementation function. Defaults to use the AttachToTangle IRI API call.
 	LocalProofOfWorkFunc pow.ProofOfWorkFunc
@@ -93,7 +173,7 @@ type QuorumHTTPClientSettings struct {
 	// A list of commands which will be executed in quorum even though they are not
 	// particularly made for such scenario. Good candidates are 'BroadcastTransactionsCmd'
 	// or 'StoreTransactionsCmd'
-	ForceQuorumSend map[IRICommand]struct{}
+	ForceQuorumSend map[api.IRICommand]struct{}
 
 	// When querying for the latest solid subtangle milestone in quorum, MaxSubtangleMilestoneDelta
 	// defines how far apart the highest and lowest latest solid subtangle milestone are allowed
@@ -106,6 +186,37 @@ type QuorumHTTPClientSettings struct {
 	// Default values which are returned when no quorum could be reached
 	// for certain types of calls.
 	Defaults *QuorumDefaults
+
+	// When enabled, GetInclusionStates and WereAddressesSpentFrom calls for which the responses
+	// as a whole don't reach the threshold are decided per element: each state is decided by
+	// its own quorum over the weighted votes of the responses. Elements which don't reach the
+	// threshold fall back to the corresponding value in Defaults. If no default is defined,
+	// the call fails with ErrQuorumNotReached.
+	PerElementQuorum bool
+
+	// An optional function which is called with the report of each call executed in quorum.
+	// Use a ReportLog to keep the most recent reports.
+	ReportHook func(report QuorumReport)
+
+	// When enabled, nodes whose latest solid subtangle milestone lags behind the highest one by more
+	// than MaxSubtangleMilestoneDelta are excluded from the latest solid subtangle milestone query
+	// instead of failing it. Lagging nodes are also excluded from all other calls until a later
+	// latest solid subtangle milestone query shows that they caught up.
+	ExcludeLaggingNodes bool
+
+	// The minimum amount of nodes which must be within MaxSubtangleMilestoneDelta when lagging
+	// nodes are excluded, otherwise the query fails with ErrNotEnoughSyncedNodes. Defaults to 2.
+	MinSyncedNodes int
+
+	// For how long nodes are excluded from calls after they reported a latest solid subtangle
+	// milestone which conflicts with the one of the majority of the nodes for the same index.
+	// Defaults to DefaultQuarantineDuration.
+	QuarantineDuration time.Duration
+
+	// Enables the circuit breaker which excludes nodes from calls after they failed
+	// too often in a row. Excluded nodes are not part of the quorum and are probed
+	// again after the configured open duration. If nil, all nodes are always queried.
+	CircuitBreaker *CircuitBreakerSettings
 }
 
 // ProofOfWorkFunc returns the defined Proof-of-Work function.
@@ -118,12 +229,126 @@ type QuorumHTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// QuorumProvider is a Provider which executes calls in quorum and
+// keeps statistics about them.
+type QuorumProvider interface {
+	api.Provider
+	// Stats returns a snapshot of the statistics of the executed quorum calls.
+	Stats() QuorumStats
+	// NodeHealth returns the health of each node as observed by the provider.
+	NodeHealth() []NodeHealth
+	// SendContext executes the given command like Send but aborts the quorum call
+	// when the given context is cancelled or its deadline is exceeded.
+	SendContext(ctx context.Context, cmd interface{}, out interface{}) error
+	// SendWithReport executes the given command like SendContext and additionally
+	// returns a report describing how the result was decided.
+	SendWithReport(ctx context.Context, cmd interface{}, out interface{}) (*QuorumReport, error)
+}
+
+// QuorumStats holds statistics about the quorum calls executed by a QuorumProvider.
+// All fields except SubtangleMilestoneDelta and LaggingNodes are counters since the creation of the provider.
+type QuorumStats struct {
+	// The amount of calls executed in quorum.
+	Calls uint64
+	// The amount of responses which voted in a quorum.
+	Votes uint64
+	// The amount of nodes which failed to give a response.
+	Failures uint64
+	// The amount of calls which exceeded the no-response tolerance.
+	NoResponseToleranceViolations uint64
+	// The amount of calls for which no quorum was reached.
+	QuorumsNotReached uint64
+	// The amount of calls for which the defaults were injected as no quorum was reached.
+	DefaultsInjected uint64
+	// The amount of calls which were decided per element as the responses as a whole didn't reach a quorum.
+	ElementQuorums uint64
+	// The amount of elements for which the defaults were injected as no quorum was reached on them.
+	ElementDefaultsInjected uint64
+	// The amount of times a command for which no quorum can be done was sent to the next
+	// candidate node as the previous one failed to give a response.
+	Failovers uint64
+	// The amount of calls which returned before all nodes responded as the quorum was already decided.
+	EarlyTerminations uint64
+	// The amount of writes which weren't accepted by enough nodes.
+	WritesNotAccepted uint64
+	// The amount of latest solid subtangle milestone queries in which nodes reported
+	// different milestones for the same index.
+	ForksDetected uint64
+	// The amount of nodes which were excluded as they lagged behind the other nodes.
+	LaggingNodesExcluded uint64
+	// The amount of calls which exceeded the max subtangle milestone delta.
+	SubtangleMilestoneDeltaViolations uint64
+	// The delta between the highest and lowest latest solid subtangle milestone of the last
+	// latest solid subtangle milestone query, including nodes which were excluded as they lagged behind.
+	SubtangleMilestoneDelta uint64
+	// The amount of nodes which were excluded from the last latest solid subtangle milestone
+	// query as they lagged behind.
+	LaggingNodes uint64
+	// The time of the last call executed in quorum (excluding latest solid subtangle
+	// milestone queries) and the error it failed with, if any.
+	LastCall      time.Time
+	LastCallError error
+	// The time of the last latest solid subtangle milestone query and the error it failed with, if any.
+	LastSubtangleQuery      time.Time
+	LastSubtangleQueryError error
+}
+
 type quorumhttpclient struct {
-	primary     Provider
-	randClients []Provider
-	client      HTTPClient
-	nodesCount  int
-	settings    *QuorumHTTPClientSettings
+	// accessed atomically, kept first for 64-bit alignment
+	roundRobin uint64
+	stats      QuorumStats
+	lastMu     sync.Mutex
+	transport  NodeTransport
+	nodesCount int
+	tracker    *nodetracker
+	settings   *QuorumHTTPClientSettings
+}
+
+// NodeHealth returns the health of each node as observed by the provider.
+func (hc *quorumhttpclient) NodeHealth() []NodeHealth {
+	return hc.tracker.snapshot()
+}
+
+// Stats returns a snapshot of the statistics of the executed quorum calls.
+func (hc *quorumhttpclient) Stats() QuorumStats {
+	stats := QuorumStats{
+		Calls:                             atomic.LoadUint64(&hc.stats.Calls),
+		Votes:                             atomic.LoadUint64(&hc.stats.Votes),
+		Failures:                          atomic.LoadUint64(&hc.stats.Failures),
+		NoResponseToleranceViolations:     atomic.LoadUint64(&hc.stats.NoResponseToleranceViolations),
+		QuorumsNotReached:                 atomic.LoadUint64(&hc.stats.QuorumsNotReached),
+		DefaultsInjected:                  atomic.LoadUint64(&hc.stats.DefaultsInjected),
+		ElementQuorums:                    atomic.LoadUint64(&hc.stats.ElementQuorums),
+		ElementDefaultsInjected:           atomic.LoadUint64(&hc.stats.ElementDefaultsInjected),
+		Failovers:                         atomic.LoadUint64(&hc.stats.Failovers),
+		EarlyTerminations:                 atomic.LoadUint64(&hc.stats.EarlyTerminations),
+		WritesNotAccepted:                 atomic.LoadUint64(&hc.stats.WritesNotAccepted),
+		LaggingNodesExcluded:              atomic.LoadUint64(&hc.stats.LaggingNodesExcluded),
+		ForksDetected:                     atomic.LoadUint64(&hc.stats.ForksDetected),
+		SubtangleMilestoneDeltaViolations: atomic.LoadUint64(&hc.stats.SubtangleMilestoneDeltaViolations),
+		SubtangleMilestoneDelta:           atomic.LoadUint64(&hc.stats.SubtangleMilestoneDelta),
+		LaggingNodes:                      atomic.LoadUint64(&hc.stats.LaggingNodes),
+	}
+	hc.lastMu.Lock()
+	stats.LastCall = hc.stats.LastCall
+	stats.LastCallError = hc.stats.LastCallError
+	stats.LastSubtangleQuery = hc.stats.LastSubtangleQuery
+	stats.LastSubtangleQueryError = hc.stats.LastSubtangleQueryError
+	hc.lastMu.Unlock()
+	return stats
+}
+
+// records the time and outcome of the last quorum call
+func (hc *quorumhttpclient) recordOutcome(isLatestSolidSubtangleQuery bool, err error) {
+	hc.lastMu.Lock()
+	defer hc.lastMu.Unlock()
+	if isLatestSolidSubtangleQuery {
+		hc.stats.LastSubtangleQuery = time.Now()
+		hc.stats.LastSubtangleQueryError = err
+		return
+	}
+	hc.stats.LastCall = time.Now()
+	hc.stats.LastCallError = err
 }
 
 // ignore
@@ -144,11 +369,28 @@ func (hc *quorumhttpclient) SetSettings(settings interface{}) error {
 		}
 	}
 
-	// set default client
-	if quSettings.Client != nil {
-		hc.client = quSettings.Client
+	// verify that the weights are positive and belong to a defined node
+	for node, weight := range quSettings.NodeWeights {
+		if weight <= 0 {
+			return errors.Wrapf(ErrInvalidNodeWeight, "%s has weight %v", node, weight)
+		}
+		var defined bool
+		for i := range quSettings.Nodes {
+			if quSettings.Nodes[i] == node {
+				defined = true
+				break
+			}
+		}
+		if !defined {
+			return errors.Wrapf(ErrInvalidNodeWeight, "%s is not part of the nodes", node)
+		}
+	}
+
+	// set the default transport
+	if quSettings.Transport != nil {
+		hc.transport = quSettings.Transport
 	} else {
-		hc.client = http.DefaultClient
+		hc.transport = &HTTPTransport{Client: quSettings.Client}
 	}
 
 	// verify that the quorum threshold makes sense
@@ -159,44 +401,65 @@ func (hc *quorumhttpclient) SetSettings(settings interface{}) error {
 	} else {
 		quSettings.Threshold = QuorumHigh
 	}
+	if quSettings.NoResponseTolerance < 0 || quSettings.NoResponseTolerance > 1 {
+		return ErrInvalidNoResponseTolerance
+	}
 
-	// set the primary node of our provider
-	if quSettings.PrimaryNode != nil {
-		// initialize the primary client
-		httpSettings := HTTPClientSettings{
-			URI:                  *quSettings.PrimaryNode,
-			Client:               quSettings.Client,
-			LocalProofOfWorkFunc: quSettings.LocalProofOfWorkFunc,
+	// verify that the thresholds and tolerances of the policies make sense
+	for command, policy := range quSettings.Policies {
+		if policy.Threshold != nil && *policy.Threshold <= MinimumQuorumThreshold {
+			return errors.Wrapf(ErrInvalidQuorumThreshold, "policy of %s", command)
 		}
+		if policy.NoResponseTolerance != nil && (*policy.NoResponseTolerance < 0 || *policy.NoResponseTolerance > 1) {
+			return errors.Wrapf(ErrInvalidNoResponseTolerance, "policy of %s", command)
+		}
+		if policy.MinAccepted < 0 || policy.MinAcceptedShare < 0 || policy.MinAcceptedShare > 1 {
+			return errors.Wrapf(ErrInvalidWriteQuorum, "policy of %s", command)
+		}
+	}
 
-		httpProvider, err := NewHTTPClient(httpSettings)
-		if err != nil {
-			return err
+	// verify the selection strategy
+	switch quSettings.Selection {
+	case "":
+		quSettings.Selection = SelectRandom
+	case SelectRandom, SelectRoundRobin, SelectLowestLatency, SelectLeastInFlight:
+	default:
+		return errors.Wrapf(ErrUnknownSelectionStrategy, "%s", quSettings.Selection)
+	}
+
+	// verify the url of the primary and failover nodes
+	if quSettings.PrimaryNode != nil {
+		if _, err := url.Parse(*quSettings.PrimaryNode); err != nil {
+			return errors.Wrap(ErrInvalidURI, *quSettings.PrimaryNode)
 		}
-		hc.primary = httpProvider
-	} else {
-		// instantiate a new provider for each single node
-		randClients := make([]Provider, len(quSettings.Nodes))
-		for i := range quSettings.Nodes {
-			httpSettings := HTTPClientSettings{
-				URI:                  quSettings.Nodes[i],
-				Client:               quSettings.Client,
-				LocalProofOfWorkFunc: quSettings.LocalProofOfWorkFunc,
-			}
-			httpProvider, err := NewHTTPClient(httpSettings)
-			if err != nil {
-				return err
-			}
-			randClients[i] = httpProvider
+	}
+	for i := range quSettings.FailoverNodes {
+		if _, err := url.Parse(quSettings.FailoverNodes[i]); err != nil {
+			return errors.Wrap(ErrInvalidURI, quSettings.FailoverNodes[i])
 		}
-		hc.randClients = randClients
 	}
 	hc.nodesCount = len(quSettings.Nodes)
+	if quSettings.MinSyncedNodes <= 0 {
+		quSettings.MinSyncedNodes = DefaultMinSyncedNodes
+	}
+	if quSettings.QuarantineDuration <= 0 {
+		quSettings.QuarantineDuration = DefaultQuarantineDuration
+	}
+	if quSettings.FailoverBackoff <= 0 {
+		quSettings.FailoverBackoff = DefaultFailoverBackoff
+	}
+	hc.tracker = newNodeTracker(quSettings.Nodes, quSettings.CircuitBreaker, quSettings.QuarantineDuration)
+	if quSettings.PrimaryNode != nil {
+		hc.tracker.add(*quSettings.PrimaryNode)
+	}
+	for i := range quSettings.FailoverNodes {
+		hc.tracker.add(quSettings.FailoverNodes[i])
+	}
 	hc.settings = &quSettings
 	return nil
 }
 
-var nonQuorumCommands = map[IRICommand]struct{}{
+var nonQuorumCommands = map[api.IRICommand]struct{}{
 	"getNodeInfo":              {},
 	"getNeighbors":             {},
 	"addNeighbors":             {},
@@ -209,74 +472,36 @@ var nonQuorumCommands = map[IRICommand]struct{}{
 	"storeTransactions":        {},
 }
 
-var subMileHashKey = [30]byte{34, 108, 97, 116, 101, 115, 116, 83, 111, 108, 105, 100, 83, 117, 98, 116, 97, 110, 103, 108, 101, 77, 105, 108, 101, 115, 116, 111, 110, 101}
-var subMileIndexKey = [34]byte{108, 97, 116, 101, 115, 116, 83, 111, 108, 105, 100, 83, 117, 98, 116, 97, 110, 103, 108, 101, 77, 105, 108, 101, 115, 116, 111, 110, 101, 73, 110, 100, 101, 120}
 var durationKey = [11]byte{34, 100, 117, 114, 97, 116, 105, 111, 110, 34, 58}
 var infoKey = [7]byte{34, 105, 110, 102, 111, 34, 58}
 var emptyInfoKey = [9]byte{34, 105, 110, 102, 111, 34, 58, 34, 34}
 
 const (
 	commaAscii             = 44
-	colonAscii             = 58
 	closingCurlyBraceAscii = 125
-	quoteAscii             = 34
 )
 
-var commaSep = [1]byte{commaAscii}
-var quoteSep = [1]byte{quoteAscii}
-var colonSep = [1]byte{colonAscii}
-
-// reduces the data of a getNodeInfo response to just the latest milestone data
-func reduceToLatestSolidSubtangleData(data []byte) ([]byte, error) {
-	indexOfSubtangleHashKey := bytes.Index(data, subMileHashKey[:])
-	if indexOfSubtangleHashKey == -1 {
-		return nil, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "subtangle milestone hash field not found")
-	}
-	indexOfSubtangleIndexKey := bytes.Index(data, subMileIndexKey[:])
-	if indexOfSubtangleIndexKey == -1 {
-		return nil, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "subtangle milestone index field not found")
-	}
-	commaIndex := bytes.Index(data[indexOfSubtangleIndexKey:], commaSep[:])
-	if commaIndex == -1 {
-		return nil, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "ending comma after subtangle milestone index field not found")
-	}
-	if indexOfSubtangleIndexKey+commaIndex > len(data) {
-		return nil, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "comma position larger than data")
-	}
-	part := data[indexOfSubtangleHashKey : indexOfSubtangleIndexKey+commaIndex]
-	// add opening/closing bracket
-	part = append([]byte{123}, part...)
-	part = append(part, 125)
-	return part, nil
+// the latest solid subtangle milestone fields of a getNodeInfo response.
+// pointers are used to detect missing fields.
+type subtangleInfo struct {
+	Hash  *string `json:"latestSolidSubtangleMilestone"`
+	Index *uint64 `json:"latestSolidSubtangleMilestoneIndex"`
 }
 
-// extracts the latest solid subtangle milestone hash of a reduced getNodeInfo response
-func extractSubTangleMilestoneHash(data []byte) (string, error) {
-	firstColonIndex := bytes.Index(data, colonSep[:])
-	if firstColonIndex == -1 {
-		return "", errors.Wrap(ErrNoLatestSolidSubtangleInfo, "unable to find hash")
-	}
-	leadingQuoteIndex := bytes.Index(data[firstColonIndex+1:], quoteSep[:])
-	if leadingQuoteIndex == -1 {
-		return "", errors.Wrap(ErrNoLatestSolidSubtangleInfo, "unable to find leading quote in hash search")
+// parses the latest solid subtangle milestone hash and index of a getNodeInfo response.
+// the fields may appear in any order and with any whitespace, all other fields are skipped.
+func parseLatestSolidSubtangleInfo(data []byte) (trinary.Hash, uint64, error) {
+	info := subtangleInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", 0, errors.Wrapf(ErrNoLatestSolidSubtangleInfo, "invalid node info: %v", err)
 	}
-	leadingQuoteIndex = firstColonIndex + 1 + leadingQuoteIndex
-	endingQuoteIndex := bytes.Index(data[leadingQuoteIndex+1:], quoteSep[:])
-	if endingQuoteIndex == -1 {
-		return "", errors.Wrap(ErrNoLatestSolidSubtangleInfo, "unable to find ending quote in hash search")
+	if info.Hash == nil || len(*info.Hash) == 0 {
+		return "", 0, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "subtangle milestone hash field not found")
 	}
-	endingQuoteIndex = leadingQuoteIndex + 1 + endingQuoteIndex
-	return string(data[leadingQuoteIndex+1 : endingQuoteIndex]), nil
-}
-
-// extracts the latest solid subtangle milestone index of a reduced getNodeInfo response
-func extractSubTangleMilestoneIndex(data []byte) (uint64, error) {
-	lastColonIndex := bytes.LastIndex(data, colonSep[:])
-	if lastColonIndex == -1 {
-		return 0, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "unable to find index")
+	if info.Index == nil {
+		return "", 0, errors.Wrap(ErrNoLatestSolidSubtangleInfo, "subtangle milestone index field not found")
 	}
-	index := data[lastColonIndex+1 : len(data)-1]
-	return strconv.ParseUint(string(index), 10, 64)
+	return *info.Hash, *info.Index, nil
 }
 
 // injects the optional default set data into the response
@@ -286,47 +511,131 @@ func (hc *quorumhttpclient) injectDefault(cmd interface{}, out interface{}) bool
 		return false
 	}
 	switch x := cmd.(type) {
-	case *WereAddressesSpentFromCommand:
+	case *api.WereAddressesSpentFromCommand:
 		if hc.settings.Defaults.WereAddressesSpentFrom != nil {
 			states := make([]bool, len(x.Addresses))
 			for i := range states {
 				states[i] = *hc.settings.Defaults.WereAddressesSpentFrom
 			}
-			out.(*WereAddressesSpentFromResponse).States = states
+			out.(*api.WereAddressesSpentFromResponse).States = states
 			return true
 		}
-	case *GetInclusionStatesCommand:
+	case *api.GetInclusionStatesCommand:
 		if hc.settings.Defaults.GetInclusionStates != nil {
 			states := make([]bool, len(x.Transactions))
 			for i := range states {
 				states[i] = *hc.settings.Defaults.GetInclusionStates
 			}
-			out.(*GetInclusionStatesResponse).States = states
+			out.(*api.GetInclusionStatesResponse).States = states
 			return true
 		}
-	case *GetBalancesCommand:
+	case *api.GetBalancesCommand:
 		if hc.settings.Defaults.GetBalances != nil {
 			balances := make([]string, len(x.Addresses))
 			for i := range balances {
 				balances[i] = strconv.Itoa(int(*hc.settings.Defaults.GetBalances))
 			}
-			out.(*GetBalancesResponse).Balances = balances
+			out.(*api.GetBalancesResponse).Balances = balances
 			return true
 		}
 	}
 	return false
 }
 
+// the response of commands returning a state per element of the command
+type statesResponse struct {
+	States []bool `json:"states"`
+}
+
+// decides the states of a GetInclusionStates or WereAddressesSpentFrom call per element.
+// responses with a non ok status code or a different amount of states abstain from voting.
+// returns false if the command doesn't support a per element quorum or no response could vote.
+func (hc *quorumhttpclient) elementQuorum(cmd interface{}, out interface{}, quorumCheck *quorumcheck, outcomes []NodeOutcome, threshold float64, report *QuorumReport) (bool, error) {
+	var count int
+	var def *bool
+	var states *[]bool
+	switch x := cmd.(type) {
+	case *api.GetInclusionStatesCommand:
+		count = len(x.Transactions)
+		if hc.settings.Defaults != nil {
+			def = hc.settings.Defaults.GetInclusionStates
+		}
+		states = &out.(*api.GetInclusionStatesResponse).States
+	case *api.WereAddressesSpentFromCommand:
+		count = len(x.Addresses)
+		if hc.settings.Defaults != nil {
+			def = hc.settings.Defaults.WereAddressesSpentFrom
+		}
+		states = &out.(*api.WereAddressesSpentFromResponse).States
+	default:
+		return false, nil
+	}
+
+	// the weighted votes for true and false per element
+	trueVotes := make([]float64, count)
+	falseVotes := make([]float64, count)
+	var voted bool
+	for _, v := range quorumCheck.votes {
+		if v.status != http.StatusOK {
+			continue
+		}
+		res := &statesResponse{}
+		if err := json.Unmarshal(v.data, res); err != nil || len(res.States) != count {
+			continue
+		}
+		voted = true
+		for i, state := range res.States {
+			if state {
+				trueVotes[i] += v.votes
+			} else {
+				falseVotes[i] += v.votes
+			}
+		}
+	}
+	if !voted {
+		return false, nil
+	}
+	atomic.AddUint64(&hc.stats.ElementQuorums, 1)
+	report.ElementQuorum = true
+
+	// abstaining responses still count towards the total,
+	// so that an element needs the same share as a whole response
+	decided := make([]bool, count)
+	for i := range decided {
+		switch {
+		case trueVotes[i]/quorumCheck.total >= threshold:
+			decided[i] = true
+		case falseVotes[i]/quorumCheck.total >= threshold:
+			decided[i] = false
+		case def != nil:
+			atomic.AddUint64(&hc.stats.ElementDefaultsInjected, 1)
+			report.DefaultsInjected = true
+			decided[i] = *def
+		default:
+			return true, newQuorumError(ErrQuorumNotReached, outcomes, quorumCheck, "element %d didn't reach the threshold of %0.2f, query (%T)", i, threshold, cmd)
+		}
+	}
+	*states = decided
+	return true, nil
+}
+
 // slices out a byte slice without the duration field.
 // querying multiple nodes will always lead to different durations
 // and hence must be removed when hasing the entire response.
 func sliceOutDurationField(data []byte) []byte {
 	indexOfDurationField := bytes.LastIndex(data, durationKey[:])
-	if indexOfDurationField == -1 {
+	if indexOfDurationField < 1 {
 		return data
 	}
 	curlyBraceIndex := bytes.Index(data[indexOfDurationField:], []byte{closingCurlyBraceAscii})
-	return append(data[:indexOfDurationField-1], data[indexOfDurationField+curlyBraceIndex:]...)
+	if curlyBraceIndex == -1 {
+		return data
+	}
+	// create a copy as the original response might still be used,
+	// i.e. when it is accepted as it is without forming a quorum
+	c := make([]byte, 0, len(data))
+	c = append(c, data[:indexOfDurationField-1]...)
+	return append(c, data[indexOfDurationField+curlyBraceIndex:]...)
 }
 
 // slices out a byte slice without the info field of check consistency calls.
@@ -348,8 +657,33 @@ func sliceOutInfoField(data []byte) []byte {
 	return append(c[:infoIndex-1], closingCurlyBraceAscii)
 }
 
+// the response of commands returning a set of hashes without guaranteed ordering
+type hashSetResponse struct {
+	Hashes []string `json:"hashes"`
+}
+
+// parses a response containing a set of hashes and returns its canonical form,
+// which are the sorted and deduplicated hashes separated by commas.
+func canonicalHashSet(data []byte) ([]byte, error) {
+	res := &hashSetResponse{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	sort.Strings(res.Hashes)
+	canonical := make([]byte, 0, len(res.Hashes)*(HashTrytesSize+1))
+	for i, hash := range res.Hashes {
+		if i > 0 && hash == res.Hashes[i-1] {
+			continue
+		}
+		canonical = append(canonical, hash...)
+		canonical = append(canonical, commaAscii)
+	}
+	return canonical, nil
+}
+
 type quorumcheck struct {
 	votes map[uint64]*quorumvote
+	total float64
 	mu    sync.Mutex
 }
 
@@ -359,14 +693,15 @@ type quorumvote struct {
 	status int
 }
 
-func (q *quorumcheck) add(hash uint64, data []byte, code int) {
+func (q *quorumcheck) add(hash uint64, data []byte, code int, weight float64) {
 	q.mu.Lock()
 	_, ok := q.votes[hash]
 	if ok {
-		q.votes[hash].votes++
+		q.votes[hash].votes += weight
 	} else {
-		q.votes[hash] = &quorumvote{votes: 1, status: code, data: data}
+		q.votes[hash] = &quorumvote{votes: weight, status: code, data: data}
 	}
+	q.total += weight
 	q.mu.Unlock()
 }
 
@@ -376,18 +711,15 @@ type subtanglecheck struct {
 	lowest      uint64
 	lowestHash  trinary.Hash
 	lowestNode  *string
-	mu          sync.Mutex
+	// the nodes which reported a given hash for a given index
+	hashes map[uint64]map[trinary.Hash][]string
+	// the index reported by each node
+	indexes map[string]uint64
+	mu      sync.Mutex
 }
 
 func (s *subtanglecheck) add(data []byte, node *string) error {
-	// reduce data
-	subtangleData, err := reduceToLatestSolidSubtangleData(data)
-	if err != nil {
-		return err
-	}
-
-	// extract index
-	index, err := extractSubTangleMilestoneIndex(subtangleData)
+	hash, index, err := parseLatestSolidSubtangleInfo(data)
 	if err != nil {
 		return err
 	}
@@ -395,12 +727,13 @@ func (s *subtanglecheck) add(data []byte, node *string) error {
 	// mutate check
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.hashes[index] == nil {
+		s.hashes[index] = make(map[trinary.Hash][]string)
+	}
+	s.hashes[index][hash] = append(s.hashes[index][hash], *node)
+	s.indexes[*node] = index
 	if index < s.lowest || s.lowest == 0 {
 		s.lowest = index
-		hash, err := extractSubTangleMilestoneHash(subtangleData)
-		if err != nil {
-			return err
-		}
 		s.lowestNode = node
 		s.lowestHash = hash
 	}
@@ -411,191 +744,647 @@ func (s *subtanglecheck) add(data []byte, node *string) error {
 	return nil
 }
 
+// marks the nodes whose index lags behind the highest index by more than the max subtangle milestone delta
+// as lagging and the other nodes as synced. the lowest index of the check is raised to the lowest index
+// of the synced nodes. returns the amount of lagging nodes.
+func (hc *quorumhttpclient) excludeLaggingNodes(s *subtanglecheck) int {
+	var lagging int
+	var lowest uint64
+	var lowestNode string
+	for node, index := range s.indexes {
+		if s.highest-index > hc.settings.MaxSubtangleMilestoneDelta {
+			hc.tracker.synced(node, index, true)
+			lagging++
+			continue
+		}
+		hc.tracker.synced(node, index, false)
+		if lowest == 0 || index < lowest {
+			lowest, lowestNode = index, node
+		}
+	}
+	atomic.AddUint64(&hc.stats.LaggingNodesExcluded, uint64(lagging))
+	if lowestNode == "" {
+		return lagging
+	}
+	s.lowest = lowest
+	s.lowestNode = &lowestNode
+	for hash := range s.hashes[lowest] {
+		s.lowestHash = hash
+	}
+	return lagging
+}
+
+// a subtangle milestone index for which nodes reported different hashes
+type subtangleconflict struct {
+	index  uint64
+	hashes map[trinary.Hash][]string
+}
+
+// returns the indexes for which nodes reported different hashes, ordered by index.
+func (s *subtanglecheck) conflicts() []subtangleconflict {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var conflicts []subtangleconflict
+	for index, hashes := range s.hashes {
+		if len(hashes) > 1 {
+			conflicts = append(conflicts, subtangleconflict{index: index, hashes: hashes})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].index < conflicts[j].index
+	})
+	return conflicts
+}
+
+// describes which nodes reported which hashes for the conflicting indexes
+func describeConflicts(conflicts []subtangleconflict) string {
+	descs := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		hashes := make([]string, 0, len(conflict.hashes))
+		for hash, nodes := range conflict.hashes {
+			hashes = append(hashes, fmt.Sprintf("%s (%s)", hash, strings.Join(nodes, ", ")))
+		}
+		sort.Strings(hashes)
+		descs[i] = fmt.Sprintf("index %d: %s", conflict.index, strings.Join(hashes, " vs. "))
+	}
+	return strings.Join(descs, "; ")
+}
+
+// quarantines the nodes which reported a minority hash for a conflicting index.
+// if no hash is backed by a strict majority of the weighted nodes, no node is quarantined
+// as it can't be determined which nodes are on the fork.
+func (hc *quorumhttpclient) quarantineMinorities(conflicts []subtangleconflict) {
+	for _, conflict := range conflicts {
+		var majority trinary.Hash
+		var majorityWeight, secondWeight float64
+		for hash, nodes := range conflict.hashes {
+			var weight float64
+			for _, node := range nodes {
+				weight += hc.weight(node)
+			}
+			switch {
+			case weight > majorityWeight:
+				majority, secondWeight, majorityWeight = hash, majorityWeight, weight
+			case weight > secondWeight:
+				secondWeight = weight
+			}
+		}
+		if majorityWeight == secondWeight {
+			continue
+		}
+		for hash, nodes := range conflict.hashes {
+			if hash == majority {
+				continue
+			}
+			reason := errors.Wrapf(ErrConflictingSubtangleMilestones, "reported %s for index %d instead of %s", hash, conflict.index, majority)
+			for _, node := range nodes {
+				hc.tracker.quarantine(node, reason)
+			}
+		}
+	}
+}
+
 // ignore
 func (hc *quorumhttpclient) Send(cmd interface{}, out interface{}) error {
-	comm, ok := cmd.(Commander)
+	return hc.SendContext(context.Background(), cmd, out)
+}
+
+// SendContext executes the given command like Send but aborts the quorum call
+// when the given context is cancelled or its deadline is exceeded.
+func (hc *quorumhttpclient) SendContext(ctx context.Context, cmd interface{}, out interface{}) error {
+	_, err := hc.SendWithReport(ctx, cmd, out)
+	return err
+}
+
+// SendWithReport executes the given command like SendContext and additionally
+// returns a report describing how the result was decided.
+func (hc *quorumhttpclient) SendWithReport(ctx context.Context, cmd interface{}, out interface{}) (*QuorumReport, error) {
+	comm, ok := cmd.(api.Commander)
 	if !ok {
-		panic("non Commander interface passed into Send()")
+		panic("non api.Commander interface passed into Send()")
 	}
+	report := &QuorumReport{Command: comm.Cmd(), Time: time.Now(), Selected: -1}
 
 	// check whether we are specifically asking for the latest solid subtangle
-	_, isLatestSolidSubtangleQuery := cmd.(*GetLatestSolidSubtangleMilestoneCommand)
+	_, isLatestSolidSubtangleQuery := cmd.(*api.GetLatestSolidSubtangleMilestoneCommand)
 
 	if !isLatestSolidSubtangleQuery {
 		// execute non quorum command on the primary or random node
 		command := comm.Cmd()
 		_, forced := hc.settings.ForceQuorumSend[command]
 		if _, ok := nonQuorumCommands[command]; ok && !forced {
-			// randomly pick up as no primary is defined
-			if hc.primary == nil {
-				provider := hc.randClients[rand.Int()%hc.nodesCount]
-				return provider.Send(cmd, out)
-			}
-			// use primary node
-			return hc.primary.Send(cmd, out)
+			outcomes, err := hc.sendFailover(ctx, cmd, out)
+			report.Duration = time.Since(report.Time)
+			report.Nodes = outcomes
+			report.Err = err
+			return report, err
+		}
+	}
+
+	report.Quorum = true
+	err := hc.sendQuorum(ctx, cmd, out, isLatestSolidSubtangleQuery, report)
+	report.Duration = time.Since(report.Time)
+	report.Err = err
+	hc.recordOutcome(isLatestSolidSubtangleQuery, err)
+	if hc.settings.ReportHook != nil {
+		hc.settings.ReportHook(*report)
+	}
+	return report, err
+}
+
+// returns the policy for the given command with all fields set,
+// falling back to the settings for fields the command's policy doesn't set.
+func (hc *quorumhttpclient) policy(cmd interface{}) CommandPolicy {
+	policy := hc.settings.Policies[cmd.(api.Commander).Cmd()]
+	if policy.Threshold == nil {
+		policy.Threshold = &hc.settings.Threshold
+	}
+	if policy.NoResponseTolerance == nil {
+		policy.NoResponseTolerance = &hc.settings.NoResponseTolerance
+	}
+	return policy
+}
+
+// returns the weight of the vote of the given node
+func (hc *quorumhttpclient) weight(node string) float64 {
+	if weight, ok := hc.settings.NodeWeights[node]; ok {
+		return weight
+	}
+	return 1
+}
+
+// the outcome of a call to a single node
+type nodeResult struct {
+	node    string
+	status  int
+	data    []byte
+	err     error
+	latency time.Duration
+}
+
+// executes the given command on the given node
+func (hc *quorumhttpclient) sendToNode(ctx context.Context, node string, b []byte, isLatestSolidSubtangleQuery bool) nodeResult {
+	res := nodeResult{node: node}
+	hc.tracker.begin(node)
+	start := time.Now()
+	res.status, res.data, res.err = hc.transport.Do(ctx, node, b)
+	res.latency = time.Since(start)
+	hc.tracker.end(node)
+	if res.err == nil && res.status != http.StatusOK && isLatestSolidSubtangleQuery {
+		res.err = ErrNonOkStatusCodeSubtangleMilestoneQuery
+	}
+	return res
+}
+
+// records the outcome of the given amount of writes which were still in flight when
+// the quorum call returned and releases their context once all of them finished.
+func (hc *quorumhttpclient) recordPendingWrites(results <-chan nodeResult, count int, cancel context.CancelFunc) {
+	defer cancel()
+	for i := 0; i < count; i++ {
+		res := <-results
+		if res.err != nil {
+			atomic.AddUint64(&hc.stats.Failures, 1)
+			hc.tracker.failure(res.node, res.err)
+			continue
 		}
+		hc.recordResponse(res)
+	}
+}
+
+// records the given response of a node in the node's health. server errors count as a failure
+// of the node, as they are usually caused by the node itself or a proxy in front of it.
+func (hc *quorumhttpclient) recordResponse(res nodeResult) {
+	if res.status >= http.StatusInternalServerError {
+		errResp := &api.ErrRequestError{Code: res.status}
+		json.Unmarshal(res.data, errResp)
+		hc.tracker.failure(res.node, errResp)
+		return
+	}
+	hc.tracker.success(res.node, res.latency)
+}
+
+// returns the nodes to use for commands for which no quorum can be done in the order
+// they are tried: the primary node, the failover nodes and the remaining nodes in the order of the selection strategy.
+func (hc *quorumhttpclient) failoverCandidates() []string {
+	candidates := make([]string, 0, len(hc.settings.FailoverNodes)+hc.nodesCount+1)
+	seen := make(map[string]struct{}, cap(candidates))
+	add := func(node string) {
+		if _, has := seen[node]; has {
+			return
+		}
+		seen[node] = struct{}{}
+		candidates = append(candidates, node)
+	}
+	if hc.settings.PrimaryNode != nil {
+		add(*hc.settings.PrimaryNode)
+	}
+	for _, node := range hc.settings.FailoverNodes {
+		add(node)
+	}
+	for _, i := range hc.selectionOrder() {
+		add(hc.settings.Nodes[i])
+	}
+	return candidates
+}
+
+// executes a command for which no quorum can be done on the first candidate node giving a response.
+// nodes which fail to respond or respond with a server error are skipped, as are nodes whose circuit
+// is open or which lag behind. without a circuit breaker, nodes whose last call failed are skipped
+// for the failover backoff. if all candidates are skipped, the first candidate which isn't quarantined
+// is used regardless.
+func (hc *quorumhttpclient) sendFailover(ctx context.Context, cmd interface{}, out interface{}) ([]NodeOutcome, error) {
+	candidates := hc.failoverCandidates()
+	var outcomes []NodeOutcome
+	var err error
+	for _, node := range candidates {
+		if hc.tracker.lagging(node) || !hc.tracker.allow(node) {
+			continue
+		}
+		if hc.settings.CircuitBreaker == nil && hc.tracker.failedRecently(node, hc.settings.FailoverBackoff) {
+			continue
+		}
+		if len(outcomes) > 0 {
+			atomic.AddUint64(&hc.stats.Failovers, 1)
+		}
+		var outcome NodeOutcome
+		outcome, err = hc.sendSingle(ctx, node, cmd, out)
+		outcomes = append(outcomes, outcome)
+		if outcome.Err == nil || (outcome.StatusCode != 0 && outcome.StatusCode < http.StatusInternalServerError) {
+			hc.tracker.success(node, outcome.Latency)
+			return outcomes, err
+		}
+		if ctx.Err() != nil {
+			hc.tracker.abort(node)
+			return outcomes, err
+		}
+		hc.tracker.failure(node, outcome.Err)
+	}
+	if len(outcomes) > 0 {
+		return outcomes, err
+	}
+	for _, node := range candidates {
+		if hc.tracker.quarantined(node) {
+			continue
+		}
+		outcome, err := hc.sendSingle(ctx, node, cmd, out)
+		return append(outcomes, outcome), err
+	}
+	return outcomes, errors.Wrapf(ErrAllNodesQuarantined, "%d nodes", len(candidates))
+}
+
+// executes the given command on a single node without forming a quorum
+func (hc *quorumhttpclient) sendSingle(ctx context.Context, node string, cmd interface{}, out interface{}) (NodeOutcome, error) {
+	outcome := NodeOutcome{URL: node, Group: -1}
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		outcome.Err = err
+		return outcome, err
+	}
+
+	res := hc.sendToNode(ctx, node, b, false)
+	outcome.StatusCode = res.status
+	outcome.Latency = res.latency
+	if res.err != nil {
+		outcome.Err = res.err
+		return outcome, res.err
 	}
 
+	if res.status != http.StatusOK {
+		errResp := &api.ErrRequestError{Code: res.status}
+		json.Unmarshal(res.data, errResp)
+		outcome.Err = errResp
+		return outcome, errResp
+	}
+
+	if out == nil {
+		return outcome, nil
+	}
+	return outcome, json.Unmarshal(res.data, out)
+}
+
+// executes the given command on all nodes and forms a quorum around the responses.
+// the call returns as soon as the quorum is decided, even if all nodes which didn't respond yet
+// would give a different response or fail to respond without exceeding the no-response tolerance,
+// and cancels the requests to the remaining nodes.
+// latest solid subtangle milestone queries always wait for all nodes, as their result depends on every single response.
+// calls which might be decided per element terminate early too, as a whole response reaching the
+// quorum also decides each of its elements.
+// writes return as soon as enough nodes accepted them or too many nodes rejected them.
+func (hc *quorumhttpclient) sendQuorum(ctx context.Context, cmd interface{}, out interface{}, isLatestSolidSubtangleQuery bool, report *QuorumReport) error {
 	// serialize
 	b, err := json.Marshal(cmd)
 	if err != nil {
 		return err
 	}
+	atomic.AddUint64(&hc.stats.Calls, 1)
+
+	// nodes with an open circuit are neither queried nor part of the denominator
+	// lagging nodes are only queried for the latest solid subtangle milestone to see whether they caught up
+	nodes := hc.tracker.allowed(hc.settings.Nodes, !isLatestSolidSubtangleQuery)
+	if len(nodes) == 0 {
+		return newQuorumError(ErrAllNodesQuarantined, nil, nil, "%d nodes", len(hc.settings.Nodes))
+	}
 
-	// for any errors which occurred during sending the request
-	errMu := sync.Mutex{}
-	anyErrors := []error{}
-	wg := sync.WaitGroup{}
-	wg.Add(len(hc.settings.Nodes))
+	// the outcome of the call on each node which responded and
+	// the amount of nodes which failed to give a response
+	outcomes := make([]NodeOutcome, 0, len(nodes))
+	errorCount := 0
 
 	// depending on the command to execute we do different checks
 	var quorumCheck *quorumcheck
 	var subtangleCheck *subtanglecheck
 
 	if isLatestSolidSubtangleQuery {
-		subtangleCheck = &subtanglecheck{}
+		subtangleCheck = &subtanglecheck{
+			hashes:  make(map[uint64]map[trinary.Hash][]string),
+			indexes: make(map[string]uint64),
+		}
 	} else {
 		quorumCheck = &quorumcheck{
 			votes: make(map[uint64]*quorumvote),
 		}
 	}
 
-	// query each not in parallel
-	for i := range hc.settings.Nodes {
-		go func(i int) {
-			defer wg.Done()
-			// add the error which occurred during this call
-			var anyError error
-			defer func() {
-				if anyError != nil {
-					errMu.Lock()
-					anyErrors = append(anyErrors, anyError)
-					errMu.Unlock()
-				}
-			}()
+	// the nodes which didn't respond yet and their weight
+	pending := make(map[string]struct{}, len(nodes))
+	var pendingWeight float64
+	for i := range nodes {
+		pending[nodes[i]] = struct{}{}
+		pendingWeight += hc.weight(nodes[i])
+	}
 
-			rd := bytes.NewReader(b)
-			req, err := http.NewRequest("POST", hc.settings.Nodes[i], rd)
-			if err != nil {
-				anyError = err
-				return
-			}
+	// fill in the outcome of each node, the vote distribution and
+	// the group of the selected response once the call finished
+	var selected *uint64
+	defer func() {
+		var groupIndex map[uint64]int
+		report.Nodes, report.Groups, groupIndex = groupVotes(withPending(outcomes, pending), quorumCheck)
+		if selected != nil {
+			report.Selected = groupIndex[*selected]
+		}
+	}()
+
+	earlyTermination := !isLatestSolidSubtangleQuery
+	policy := hc.policy(cmd)
+	acceptAny := policy.AcceptAny && !isLatestSolidSubtangleQuery
+	var accepted []byte
+
+	// writes are grouped by the status code of the responses instead of their content
+	write := policy.write() && !isLatestSolidSubtangleQuery
+	minAccepted := policy.minAccepted(len(nodes))
+	acceptedCount := 0
+	report.WriteQuorum = write
+
+	// query each node in parallel, the requests to the remaining nodes are cancelled once the quorum
+	// is decided. writes are sent to every node regardless of when the call returns, as each node
+	// which receives the write helps its propagation.
+	nodesCtx, cancel := context.WithCancel(ctx)
+	if write {
+		nodesCtx, cancel = context.WithCancel(context.Background())
+	}
+	results := make(chan nodeResult, len(nodes))
+	defer func() {
+		if write {
+			go hc.recordPendingWrites(results, len(pending), cancel)
+			return
+		}
+		cancel()
+		// requests to pending nodes which are cancelled neither
+		// count as a success nor as a failure of the node
+		for node := range pending {
+			hc.tracker.abort(node)
+		}
+	}()
+	for i := range nodes {
+		go func(node string) {
+			results <- hc.sendToNode(nodesCtx, node, b, isLatestSolidSubtangleQuery)
+		}(nodes[i])
+	}
 
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("X-IOTA-API-Version", "1")
-			resp, err := hc.client.Do(req)
-			if err != nil {
-				anyError = err
-				return
-			}
-			defer resp.Body.Close()
+collect:
+	for range nodes {
+		var res nodeResult
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "quorum call aborted, query (%T)", cmd)
+		}
+		delete(pending, res.node)
+		weight := hc.weight(res.node)
+		pendingWeight -= weight
+
+		// extract only latest solid subtangle data from get node info
+		// call to be able to form a quorum around that response
+		if res.err == nil && isLatestSolidSubtangleQuery {
+			node := res.node
+			res.err = subtangleCheck.add(res.data, &node)
+		}
 
-			if resp.StatusCode != http.StatusOK && isLatestSolidSubtangleQuery {
-				anyError = ErrNonOkStatusCodeSubtangleMilestoneQuery;
-				return
+		outcome := NodeOutcome{URL: res.node, Err: res.err, StatusCode: res.status, Latency: res.latency, Group: -1}
+		if res.err != nil {
+			atomic.AddUint64(&hc.stats.Failures, 1)
+			hc.tracker.failure(res.node, res.err)
+			outcomes = append(outcomes, outcome)
+			errorCount++
+			// the write can't be accepted by enough nodes anymore
+			if write && acceptedCount+len(pending) < minAccepted {
+				break collect
 			}
+			continue
+		}
+		hc.recordResponse(res)
+		atomic.AddUint64(&hc.stats.Votes, 1)
 
-			data, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				anyError = err
-				return
-			}
+		if isLatestSolidSubtangleQuery {
+			outcomes = append(outcomes, outcome)
+			continue
+		}
 
-			// extract only latest solid subtangle data from get node info
-			// call to be able to form a quorum around that response
-			if isLatestSolidSubtangleQuery {
-				if err := subtangleCheck.add(data, &hc.settings.Nodes[i]); err != nil {
-					anyError = err
+		if write {
+			hash := uint64(res.status)
+			quorumCheck.add(hash, res.data, res.status, weight)
+			outcome.Group = 0
+			outcome.hash = hash
+			outcomes = append(outcomes, outcome)
+			if res.status != http.StatusOK {
+				if acceptedCount+len(pending) < minAccepted {
+					break collect
+				}
+				continue
+			}
+			acceptedCount++
+			if accepted == nil {
+				accepted = res.data
+			}
+			if acceptedCount >= minAccepted {
+				selected = &hash
+				if len(pending) > 0 {
+					atomic.AddUint64(&hc.stats.EarlyTerminations, 1)
+					report.EarlyTermination = true
 				}
-				return
+				break collect
 			}
+			continue
+		}
 
-			// remove the duration field from the response
-			// as multiple nodes will always give a different answer
-			data = sliceOutDurationField(data)
+		// remove the duration field from the response
+		// as multiple nodes will always give a different answer
+		data := sliceOutDurationField(res.data)
 
-			var hash uint64
+		var hash uint64
 
-			switch cmd.(type) {
-			case *FindTransactionsCommand:
-				// as findTransactions responses don't guarantee ordering, we just simply
-				// sum up the bytes of the reduced response. it's highly unlikely that responses
-				// with different hashes will sum up to the same number.
-				var sum uint64
-				for _, b := range data {
-					sum += uint64(b)
-				}
-				// shift the sum by the length of the data, thereby
-				// distancing responses with different lengths
-				sum *= uint64(len(data))
-				hash = sum
-			case *CheckConsistencyCommand:
-				// we slice out the info field from check consistency calls
-				// but use whatever first info response was given when actually
-				// returning the result from this API call
-				cleaned := sliceOutInfoField(data)
-				hash = xxhash.Sum64(cleaned)
-			default:
+		switch cmd.(type) {
+		case *api.FindTransactionsCommand, *api.GetTipsCommand:
+			// as the hashes of these responses don't guarantee ordering, we hash
+			// their canonical form instead. error responses are hashed as they are.
+			// GetTips is a non quorum command and only ends up here if it is forced via ForceQuorumSend.
+			if res.status != http.StatusOK {
 				hash = xxhash.Sum64(data)
+				break
 			}
-			// add quorum vote
-			quorumCheck.add(hash, data, resp.StatusCode)
-		}(i)
+			canonical, err := canonicalHashSet(data)
+			if err != nil {
+				hash = xxhash.Sum64(data)
+				break
+			}
+			hash = xxhash.Sum64(canonical)
+		case *api.CheckConsistencyCommand:
+			// we slice out the info field from check consistency calls
+			// but use whatever first info response was given when actually
+			// returning the result from this API call
+			cleaned := sliceOutInfoField(data)
+			hash = xxhash.Sum64(cleaned)
+		default:
+			hash = xxhash.Sum64(data)
+		}
+		// add quorum vote
+		quorumCheck.add(hash, data, res.status, weight)
+		outcome.Group = 0
+		outcome.hash = hash
+		outcomes = append(outcomes, outcome)
+
+		// the first valid response is used if the command's policy accepts any response
+		if acceptAny && res.status == http.StatusOK {
+			selected = &hash
+			accepted = res.data
+			break collect
+		}
+
+		// the quorum is decided if the response reaches the threshold even when all pending
+		// nodes give a different response and the no-response tolerance can't be exceeded anymore
+		// even when all pending nodes fail to respond
+		if earlyTermination && len(pending) > 0 && quorumCheck.votes[hash].votes/(quorumCheck.total+pendingWeight) >= *policy.Threshold &&
+			float64(errorCount+len(pending))/float64(len(nodes)) <= *policy.NoResponseTolerance {
+			atomic.AddUint64(&hc.stats.EarlyTerminations, 1)
+			report.EarlyTermination = true
+			break collect
+		}
+	}
+
+	if write {
+		queried := len(nodes)
+		report.Percentage = float64(acceptedCount) / float64(queried)
+		if acceptedCount < minAccepted {
+			atomic.AddUint64(&hc.stats.WritesNotAccepted, 1)
+			return newQuorumError(ErrWriteQuorumNotReached, withPending(outcomes, pending), quorumCheck, "%d of min. %d of %d nodes accepted the write, query (%T)", acceptedCount, minAccepted, queried, cmd)
+		}
+	}
+
+	if accepted != nil {
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(accepted, out)
 	}
-	wg.Wait()
 
 	// check how many nodes failed to give a response
 	// and then check whether we violated the no-response tolerance
-	errorCount := len(anyErrors)
-	percOfFailedResp := float64(errorCount) / float64(hc.nodesCount)
-	if percOfFailedResp > hc.settings.NoResponseTolerance {
+	queried := len(nodes)
+	percOfFailedResp := float64(errorCount) / float64(queried)
+	if percOfFailedResp > *policy.NoResponseTolerance {
+		atomic.AddUint64(&hc.stats.NoResponseToleranceViolations, 1)
 		perc := math.Round(percOfFailedResp * 100)
-		return errors.Wrapf(ErrExceededNoResponseTolerance, "%d%% of nodes failed to give a response, first error '%v'", int(perc), anyErrors[0].Error())
+		return newQuorumError(ErrExceededNoResponseTolerance, outcomes, quorumCheck, "%d%% of nodes failed to give a response", int(perc))
 	}
 
 	// when querying for the latest solid subtangle milestone,
 	// we do not apply the default quorum behavior but take the MaxSubtangleMilestoneDelta into consideration.
 	// note that we explicitly check the status code in the response against the NoResponseTolerance.
 	if isLatestSolidSubtangleQuery {
-		delta := subtangleCheck.highest - subtangleCheck.lowest
-		if delta > hc.settings.MaxSubtangleMilestoneDelta {
-			return errors.Wrapf(ErrExceededMaxSubtangleMilestoneDelta, "lowest node (%s) has %d, highest node (%s) has %d, max. allowed delta %d",
+		// the delta across all responding nodes, as excluding lagging nodes would hide how far they lag behind
+		atomic.StoreUint64(&hc.stats.SubtangleMilestoneDelta, subtangleCheck.highest-subtangleCheck.lowest)
+
+		// nodes reporting different milestones for the same index are on a fork
+		if conflicts := subtangleCheck.conflicts(); len(conflicts) > 0 {
+			atomic.AddUint64(&hc.stats.ForksDetected, 1)
+			hc.quarantineMinorities(conflicts)
+			return newQuorumError(ErrConflictingSubtangleMilestones, outcomes, nil, "%s", describeConflicts(conflicts))
+		}
+
+		// exclude the nodes lagging behind and mark the others as synced
+		if hc.settings.ExcludeLaggingNodes {
+			lagging := hc.excludeLaggingNodes(subtangleCheck)
+			atomic.StoreUint64(&hc.stats.LaggingNodes, uint64(lagging))
+			if synced := len(subtangleCheck.indexes) - lagging; synced < hc.settings.MinSyncedNodes {
+				return newQuorumError(ErrNotEnoughSyncedNodes, outcomes, nil, "%d of min. %d nodes are within a delta of %d to the highest subtangle milestone %d",
+					synced, hc.settings.MinSyncedNodes, hc.settings.MaxSubtangleMilestoneDelta, subtangleCheck.highest)
+			}
+		}
+
+		if subtangleCheck.highest-subtangleCheck.lowest > hc.settings.MaxSubtangleMilestoneDelta {
+			atomic.AddUint64(&hc.stats.SubtangleMilestoneDeltaViolations, 1)
+			return newQuorumError(ErrExceededMaxSubtangleMilestoneDelta, outcomes, nil, "lowest node (%s) has %d, highest node (%s) has %d, max. allowed delta %d",
 				*subtangleCheck.lowestNode, subtangleCheck.lowest,
 				*subtangleCheck.highestNode, subtangleCheck.highest, hc.settings.MaxSubtangleMilestoneDelta)
 		}
-		o := out.(*GetLatestSolidSubtangleMilestoneResponse)
+		o := out.(*api.GetLatestSolidSubtangleMilestoneResponse)
 		o.LatestSolidSubtangleMilestone = subtangleCheck.lowestHash
 		o.LatestSolidSubtangleMilestoneIndex = int64(subtangleCheck.lowest)
 		return nil
 	}
 
 	var mostVotes float64
-	var selected uint64
+	var mostVoted uint64
 	for key, v := range quorumCheck.votes {
 		if mostVotes < v.votes {
 			mostVotes = v.votes
-			selected = key
+			mostVoted = key
 		}
 	}
 
-	// check whether quorum is over threshold
-	percentage := mostVotes / float64(hc.nodesCount-errorCount)
-	if percentage < hc.settings.Threshold {
+	// check whether quorum is over threshold, the share of votes
+	// is weighted by the nodes' weights which default to 1
+	percentage := mostVotes / quorumCheck.total
+	report.Percentage = percentage
+	if percentage < *policy.Threshold {
+		// decide each element on its own if the responses as a whole differ
+		if hc.settings.PerElementQuorum {
+			if decided, err := hc.elementQuorum(cmd, out, quorumCheck, outcomes, *policy.Threshold, report); decided {
+				if err != nil {
+					atomic.AddUint64(&hc.stats.QuorumsNotReached, 1)
+				}
+				return err
+			}
+		}
+		atomic.AddUint64(&hc.stats.QuorumsNotReached, 1)
 		// automatically inject the default value set by the library user
 		// in case no quorum was reached. If no defaults are set, then
 		// the default error is returned indicating that no quorum was reached
 		if hc.injectDefault(cmd, out) {
+			atomic.AddUint64(&hc.stats.DefaultsInjected, 1)
+			report.DefaultsInjected = true
 			return nil
 		}
-		return errors.Wrapf(ErrQuorumNotReached, "%0.2f of needed %0.2f reached, query (%T)", percentage, hc.settings.Threshold, cmd)
+		return newQuorumError(ErrQuorumNotReached, outcomes, quorumCheck, "%0.2f of needed %0.2f reached, query (%T)", percentage, *policy.Threshold, cmd)
 	}
+	selected = &mostVoted
 
 	// extract final result and status code
-	statusCode := quorumCheck.votes[selected].status
-	result := quorumCheck.votes[selected].data
+	statusCode := quorumCheck.votes[mostVoted].status
+	result := quorumCheck.votes[mostVoted].data
 
 	if statusCode != http.StatusOK {
-		errResp := &ErrRequestError{Code: statusCode}
+		errResp := &api.ErrRequestError{Code: statusCode}
 		json.Unmarshal(result, errResp)
 		return errResp
 	}
@@ -0,0 +1,774 @@
+package quorum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/iotaledger/iota.go/api"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResponse is the response of a node of the fakeTransport.
+type fakeResponse struct {
+	status int
+	body   string
+	err    error
+	delay  time.Duration
+}
+
+// fakeTransport answers each node with a fixed response after an optional delay.
+type fakeTransport map[string]fakeResponse
+
+func (t fakeTransport) Do(ctx context.Context, node string, payload []byte) (int, []byte, error) {
+	res, ok := t[node]
+	if !ok {
+		return 0, nil, errors.Errorf("unknown node %s", node)
+	}
+	if res.delay > 0 {
+		select {
+		case <-time.After(res.delay):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+	return res.status, []byte(res.body), res.err
+}
+
+func (t fakeTransport) nodes() []string {
+	nodes := make([]string, 0, len(t))
+	for node := range t {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func newTestProvider(t *testing.T, transport fakeTransport, settings QuorumHTTPClientSettings) QuorumProvider {
+	t.Helper()
+	settings.Nodes = transport.nodes()
+	settings.Transport = transport
+	provider, err := NewQuorumHTTPClient(settings)
+	if err != nil {
+		t.Fatalf("unable to create provider: %v", err)
+	}
+	return provider.(QuorumProvider)
+}
+
+const balancesResponse = `{"balances":["10"],"references":["A"],"milestoneIndex":1,"duration":3}`
+
+func balancesCommand() *api.GetBalancesCommand {
+	return &api.GetBalancesCommand{Command: api.Command{Command: api.GetBalancesCmd}, Addresses: []string{"A"}, Threshold: 100}
+}
+
+func TestEarlyTerminationRespectsNoResponseTolerance(t *testing.T) {
+	slowFailure := fakeResponse{err: errors.New("timeout"), delay: 50 * time.Millisecond}
+	transport := fakeTransport{
+		"a": {status: 200, body: balancesResponse},
+		"b": {status: 200, body: balancesResponse},
+		"c": {status: 200, body: balancesResponse},
+		"d": slowFailure,
+		"e": slowFailure,
+	}
+
+	// the agreeing nodes reach the threshold before the failing nodes respond,
+	// but 2 of 5 failing nodes exceed the tolerance regardless of the timing
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6, NoResponseTolerance: 0.2})
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if errors.Cause(err) != ErrExceededNoResponseTolerance {
+		t.Fatalf("expected ErrExceededNoResponseTolerance, got %v", err)
+	}
+	if report.EarlyTermination {
+		t.Fatal("the call must wait for the failing nodes")
+	}
+
+	// with a tolerance of 40% the call is decided once the agreeing nodes responded
+	provider = newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6, NoResponseTolerance: 0.4})
+	out := &api.GetBalancesResponse{}
+	report, err = provider.SendWithReport(context.Background(), balancesCommand(), out)
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if !report.EarlyTermination || len(report.Agreed()) != 3 {
+		t.Fatalf("expected an early termination agreed on by 3 nodes, got %+v", report)
+	}
+	if len(out.Balances) != 1 || out.Balances[0] != "10" {
+		t.Fatalf("unexpected balances: %v", out.Balances)
+	}
+}
+
+func TestEarlyTerminationWaitsForUndecidedQuorum(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: balancesResponse},
+		"b": {status: 200, body: balancesResponse},
+		"c": {status: 200, body: `{"balances":["0"],"references":["A"],"milestoneIndex":1}`, delay: 10 * time.Millisecond},
+		"d": {status: 200, body: balancesResponse, delay: 30 * time.Millisecond},
+	}
+	// 2 of 4 votes after the dissenting node responded don't decide a threshold of 70%
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.7, NoResponseTolerance: 0.25})
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if report.EarlyTermination || len(report.Nodes) != 4 {
+		t.Fatalf("expected the call to wait for all nodes, got %+v", report)
+	}
+	if report.Percentage != 0.75 || len(report.Dissented()) != 1 || report.Dissented()[0] != "c" {
+		t.Fatalf("unexpected vote distribution: %+v", report)
+	}
+}
+
+func TestEarlyTerminationWithPerElementQuorum(t *testing.T) {
+	states := `{"states":[true,false],"duration":1}`
+	transport := fakeTransport{
+		"a": {status: 200, body: states},
+		"b": {status: 200, body: states},
+		"c": {status: 200, body: states},
+		"d": {status: 200, body: states},
+		"e": {status: 200, body: states, delay: time.Second},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.66, NoResponseTolerance: 0.2, PerElementQuorum: true})
+	cmd := &api.GetInclusionStatesCommand{Command: api.Command{Command: api.GetInclusionStatesCmd}, Transactions: []trinary.Hash{"A", "B"}, Tips: []trinary.Hash{"C"}}
+	out := &api.GetInclusionStatesResponse{}
+	report, err := provider.SendWithReport(context.Background(), cmd, out)
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if !report.EarlyTermination {
+		t.Fatalf("expected the call to terminate early, got %+v", report)
+	}
+	if len(out.States) != 2 || !out.States[0] || out.States[1] {
+		t.Fatalf("unexpected states: %v", out.States)
+	}
+}
+
+func TestWeightedVotes(t *testing.T) {
+	transport := fakeTransport{
+		"heavy":  {status: 200, body: balancesResponse},
+		"light1": {status: 200, body: `{"balances":["0"],"references":["A"],"milestoneIndex":1}`},
+		"light2": {status: 200, body: `{"balances":["0"],"references":["A"],"milestoneIndex":1}`},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6, NodeWeights: map[string]float64{"heavy": 3}})
+	out := &api.GetBalancesResponse{}
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), out)
+	if err != nil {
+		t.Fatalf("expected the heavy node to reach the quorum, got %v", err)
+	}
+	if len(out.Balances) != 1 || out.Balances[0] != "10" {
+		t.Fatalf("expected the response of the heavy node, got %+v", out)
+	}
+	if report.Percentage != 0.6 || len(report.Dissented()) != 2 {
+		t.Fatalf("unexpected vote distribution: %+v", report)
+	}
+}
+
+func TestEarlyTerminationWithWeightedVotes(t *testing.T) {
+	transport := fakeTransport{
+		"heavy": {status: 200, body: balancesResponse},
+		"b":     {status: 200, body: balancesResponse},
+		"c":     {status: 200, body: balancesResponse, delay: time.Second},
+		"d":     {status: 200, body: balancesResponse, delay: time.Second},
+	}
+	// 5 of 7 weighted votes decide the quorum although only 2 of 4 nodes responded
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		Threshold:           0.7,
+		NoResponseTolerance: 0.5,
+		NodeWeights:         map[string]float64{"heavy": 4},
+	})
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if !report.EarlyTermination || report.Duration >= time.Second {
+		t.Fatalf("expected the call to terminate early, got %+v", report)
+	}
+	if pending := report.Pending(); len(pending) != 2 {
+		t.Fatalf("expected c and d to be pending, got %v", pending)
+	}
+}
+
+func TestCanonicalHashSet(t *testing.T) {
+	canonical := func(data string) string {
+		c, err := canonicalHashSet([]byte(data))
+		if err != nil {
+			t.Fatalf("unable to compute canonical form of %s: %v", data, err)
+		}
+		return string(c)
+	}
+	set := canonical(`{"hashes":["AB","CD","EF"],"duration":1}`)
+	if set != "AB,CD,EF," {
+		t.Fatalf("unexpected canonical form: %s", set)
+	}
+	if reordered := canonical(`{"hashes":["EF","AB","CD","AB"],"duration":3}`); reordered != set {
+		t.Fatalf("expected reordered and duplicated hashes to be equal, got %s", reordered)
+	}
+
+	// sets made up of the same bytes collided when summing up the bytes of the responses
+	byteSum := func(data string) uint64 {
+		var sum uint64
+		for _, b := range []byte(data) {
+			sum += uint64(b)
+		}
+		return sum * uint64(len(data))
+	}
+	a, b := `{"hashes":["AB","CD"]}`, `{"hashes":["AC","BD"]}`
+	if byteSum(a) != byteSum(b) {
+		t.Fatal("expected the sets to collide when summing up their bytes")
+	}
+	if canonical(a) == canonical(b) {
+		t.Fatalf("expected different sets to differ, got %s", canonical(a))
+	}
+
+	if _, err := canonicalHashSet([]byte(`{"hashes":`)); err == nil {
+		t.Fatal("expected an error for a malformed response")
+	}
+}
+
+func TestHashSetVotes(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: `{"hashes":["AB","CD"],"duration":1}`},
+		"b": {status: 200, body: `{"hashes":["CD","AB","CD"],"duration":2}`},
+		"c": {status: 200, body: `{"hashes":["AC","BD"],"duration":1}`},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6})
+	cmd := &api.FindTransactionsCommand{Command: api.Command{Command: api.FindTransactionsCmd}, FindTransactionsQuery: api.FindTransactionsQuery{Tags: []trinary.Trytes{"CONFBOX"}}}
+	report, err := provider.SendWithReport(context.Background(), cmd, &api.FindTransactionsResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if len(report.Groups) != 2 || len(report.Dissented()) != 1 || report.Dissented()[0] != "c" {
+		t.Fatalf("expected a and b to vote together, got %+v", report)
+	}
+}
+
+func inclusionStatesCommand(transactions ...trinary.Hash) *api.GetInclusionStatesCommand {
+	return &api.GetInclusionStatesCommand{Command: api.Command{Command: api.GetInclusionStatesCmd}, Transactions: transactions, Tips: []trinary.Hash{"TIP"}}
+}
+
+func statesBody(states string) fakeResponse {
+	return fakeResponse{status: 200, body: `{"states":` + states + `,"duration":1}`}
+}
+
+func TestElementQuorum(t *testing.T) {
+	transport := fakeTransport{
+		"a": statesBody(`[true,true,false]`),
+		"b": statesBody(`[true,false,false]`),
+		"c": statesBody(`[true,true,true]`),
+		"d": statesBody(`[false,true,false]`),
+		"e": statesBody(`[false,true,true]`),
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6, PerElementQuorum: true})
+	out := &api.GetInclusionStatesResponse{}
+	report, err := provider.SendWithReport(context.Background(), inclusionStatesCommand("A", "B", "C"), out)
+	if err != nil {
+		t.Fatalf("expected each element to reach the quorum, got %v", err)
+	}
+	if len(out.States) != 3 || !out.States[0] || !out.States[1] || out.States[2] {
+		t.Fatalf("unexpected states: %v", out.States)
+	}
+	if !report.ElementQuorum || report.DefaultsInjected {
+		t.Fatalf("expected the call to be decided per element, got %+v", report)
+	}
+	if stats := provider.Stats(); stats.ElementQuorums != 1 || stats.ElementDefaultsInjected != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestElementQuorumAbstainingResponses(t *testing.T) {
+	transport := fakeTransport{
+		"a": statesBody(`[true,true]`),
+		"b": statesBody(`[true,false]`),
+		"c": statesBody(`[true,true]`),
+		"x": {status: 400, body: `{"error":"invalid tips"}`},
+		"y": statesBody(`[true]`),
+	}
+	// the abstaining responses count towards the total, so only the first element reaches the threshold
+	def := false
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		Threshold:        0.6,
+		PerElementQuorum: true,
+		Defaults:         &QuorumDefaults{GetInclusionStates: &def},
+	})
+	out := &api.GetInclusionStatesResponse{}
+	report, err := provider.SendWithReport(context.Background(), inclusionStatesCommand("A", "B"), out)
+	if err != nil {
+		t.Fatalf("expected the default to be injected, got %v", err)
+	}
+	if len(out.States) != 2 || !out.States[0] || out.States[1] {
+		t.Fatalf("unexpected states: %v", out.States)
+	}
+	if !report.ElementQuorum || !report.DefaultsInjected {
+		t.Fatalf("expected the default to be injected for the second element, got %+v", report)
+	}
+	if injected := provider.Stats().ElementDefaultsInjected; injected != 1 {
+		t.Fatalf("expected 1 element default to be injected, got %d", injected)
+	}
+
+	// without a default the undecided element fails the call
+	provider = newTestProvider(t, transport, QuorumHTTPClientSettings{Threshold: 0.6, PerElementQuorum: true})
+	_, err = provider.SendWithReport(context.Background(), inclusionStatesCommand("A", "B"), &api.GetInclusionStatesResponse{})
+	if errors.Cause(err) != ErrQuorumNotReached {
+		t.Fatalf("expected ErrQuorumNotReached, got %v", err)
+	}
+	if qErr, ok := err.(*QuorumError); !ok || !strings.Contains(qErr.Message, "element 1") {
+		t.Fatalf("expected the error to name the undecided element, got %v", err)
+	}
+}
+
+func TestFailoverSkipsFailedNodesWithoutCircuitBreaker(t *testing.T) {
+	transport := fakeTransport{
+		"primary": {err: errors.New("connection refused")},
+		"b":       {status: 200, body: `{"trunkTransaction":"A","branchTransaction":"B","duration":1}`},
+	}
+	primary := "primary"
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{PrimaryNode: &primary})
+	cmd := &api.GetTransactionsToApproveCommand{Command: api.Command{Command: api.GetTransactionsToApproveCmd}, Depth: 3}
+
+	report, err := provider.SendWithReport(context.Background(), cmd, &api.GetTransactionsToApproveResponse{})
+	if err != nil {
+		t.Fatalf("expected the call to fail over, got %v", err)
+	}
+	if len(report.Nodes) != 2 || report.Nodes[0].URL != "primary" || report.Nodes[1].URL != "b" {
+		t.Fatalf("expected the primary and then b to be called, got %+v", report.Nodes)
+	}
+
+	// the failed primary is skipped for the failover backoff
+	report, err = provider.SendWithReport(context.Background(), cmd, &api.GetTransactionsToApproveResponse{})
+	if err != nil {
+		t.Fatalf("expected the call to succeed, got %v", err)
+	}
+	if len(report.Nodes) != 1 || report.Nodes[0].URL != "b" {
+		t.Fatalf("expected only b to be called, got %+v", report.Nodes)
+	}
+	if failovers := provider.Stats().Failovers; failovers != 1 {
+		t.Fatalf("expected 1 failover, got %d", failovers)
+	}
+}
+
+const milestoneHash = "MILESTONE99999999999999999999999999999999999999999999999999999999999999999999999"
+
+func TestParseLatestSolidSubtangleInfo(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		hash  string
+		index uint64
+		ok    bool
+	}{
+		{"compact", `{"appName":"IRI","latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":1050123,"duration":1}`, milestoneHash, 1050123, true},
+		{"reordered fields", `{"latestSolidSubtangleMilestoneIndex":1050123,"duration":1,"latestSolidSubtangleMilestone":"` + milestoneHash + `","appName":"IRI"}`, milestoneHash, 1050123, true},
+		{"pretty-printed", "{\n  \"appName\": \"IRI\",\n  \"latestSolidSubtangleMilestone\" : \"" + milestoneHash + "\",\n\t\"latestSolidSubtangleMilestoneIndex\" :\r\n 7\n}\n", milestoneHash, 7, true},
+		{"index last", `{"latestSolidSubtangleMilestone":"` + milestoneHash + `","neighbors":2,"latestSolidSubtangleMilestoneIndex":42}`, milestoneHash, 42, true},
+		{"nested objects are skipped", `{"features":{"latestSolidSubtangleMilestone":"X"},"latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":1}`, milestoneHash, 1, true},
+		{"missing hash", `{"latestSolidSubtangleMilestoneIndex":1050123}`, "", 0, false},
+		{"empty hash", `{"latestSolidSubtangleMilestone":"","latestSolidSubtangleMilestoneIndex":1050123}`, "", 0, false},
+		{"null hash", `{"latestSolidSubtangleMilestone":null,"latestSolidSubtangleMilestoneIndex":1050123}`, "", 0, false},
+		{"missing index", `{"latestSolidSubtangleMilestone":"` + milestoneHash + `"}`, "", 0, false},
+		{"negative index", `{"latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":-1}`, "", 0, false},
+		{"string index", `{"latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":"1"}`, "", 0, false},
+		{"truncated", `{"latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":105`, "", 0, false},
+		{"truncated within the hash", `{"latestSolidSubtangleMilestone":"MILESTO`, "", 0, false},
+		{"empty", ``, "", 0, false},
+		{"not an object", `["latestSolidSubtangleMilestone"]`, "", 0, false},
+	}
+	for _, test := range tests {
+		hash, index, err := parseLatestSolidSubtangleInfo([]byte(test.data))
+		if !test.ok {
+			if errors.Cause(err) != ErrNoLatestSolidSubtangleInfo {
+				t.Errorf("%s: expected ErrNoLatestSolidSubtangleInfo, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if hash != test.hash || index != test.index {
+			t.Errorf("%s: expected %s/%d, got %s/%d", test.name, test.hash, test.index, hash, index)
+		}
+	}
+}
+
+func FuzzParseLatestSolidSubtangleInfo(f *testing.F) {
+	f.Add([]byte(`{"latestSolidSubtangleMilestone":"` + milestoneHash + `","latestSolidSubtangleMilestoneIndex":1050123}`))
+	f.Add([]byte(`{"latestSolidSubtangleMilestoneIndex":1,"latestSolidSubtangleMilestone":"A","duration":0}`))
+	f.Add([]byte("{\n \"latestSolidSubtangleMilestone\": \"A\",\n \"latestSolidSubtangleMilestoneIndex\": 18446744073709551615\n}"))
+	f.Add([]byte(`{"latestSolidSubtangleMilestone":"A","latestSolidSubtangleMilestoneIndex":`))
+	f.Add([]byte(`{"latestSolidSubtangleMilestone":null}`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		hash, index, err := parseLatestSolidSubtangleInfo(data)
+		if err != nil {
+			if errors.Cause(err) != ErrNoLatestSolidSubtangleInfo {
+				t.Fatalf("expected ErrNoLatestSolidSubtangleInfo, got %v", err)
+			}
+			return
+		}
+		if len(hash) == 0 {
+			t.Fatal("a parsed hash must not be empty")
+		}
+		// the parsed fields must survive a round trip
+		reencoded, err := json.Marshal(map[string]interface{}{
+			"latestSolidSubtangleMilestone":      hash,
+			"latestSolidSubtangleMilestoneIndex": index,
+		})
+		if err != nil {
+			t.Fatalf("unable to encode the parsed fields: %v", err)
+		}
+		reparsedHash, reparsedIndex, err := parseLatestSolidSubtangleInfo(reencoded)
+		if err != nil || reparsedHash != hash || reparsedIndex != index {
+			t.Fatalf("round trip of %s/%d failed: %s/%d, %v", hash, index, reparsedHash, reparsedIndex, err)
+		}
+	})
+}
+
+func TestAcceptAnyPolicy(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: `{"trytes":["AAA"],"duration":3}`},
+		"b": {status: 200, body: `{"trytes":["BBB"],"duration":5}`, delay: 50 * time.Millisecond},
+		"c": {status: 200, body: `{"trytes":["CCC"],"duration":1}`, delay: 50 * time.Millisecond},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		Policies: map[api.IRICommand]CommandPolicy{api.GetTrytesCmd: {AcceptAny: true}},
+	})
+	out := &api.GetTrytesResponse{}
+	cmd := &api.GetTrytesCommand{Command: api.Command{Command: api.GetTrytesCmd}, Hashes: []string{"A"}}
+	report, err := provider.SendWithReport(context.Background(), cmd, out)
+	if err != nil {
+		t.Fatalf("expected the first response to be accepted, got %v", err)
+	}
+	if len(out.Trytes) != 1 || out.Trytes[0] != "AAA" {
+		t.Fatalf("unexpected trytes: %v", out.Trytes)
+	}
+	if agreed := report.Agreed(); len(agreed) != 1 || agreed[0] != "a" {
+		t.Fatalf("expected the response of a to be selected, got %v", agreed)
+	}
+}
+
+func TestSliceOutDurationFieldKeepsOriginal(t *testing.T) {
+	data := []byte(`{"trytes":["AAA"],"duration":3}`)
+	sliced := sliceOutDurationField(data)
+	if string(sliced) != `{"trytes":["AAA"]}` {
+		t.Fatalf("unexpected sliced response: %s", sliced)
+	}
+	if string(data) != `{"trytes":["AAA"],"duration":3}` {
+		t.Fatalf("the original response must not be modified: %s", data)
+	}
+	for _, malformed := range []string{`"duration":3}`, `{"trytes":[],"duration":3`} {
+		if sliced := sliceOutDurationField([]byte(malformed)); string(sliced) != malformed {
+			t.Errorf("expected %s to be kept as it is, got %s", malformed, sliced)
+		}
+	}
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	half, negative, tooHigh := 0.5, -0.1, 1.5
+	tests := []struct {
+		settings QuorumHTTPClientSettings
+		err      error
+	}{
+		{QuorumHTTPClientSettings{NoResponseTolerance: tooHigh}, ErrInvalidNoResponseTolerance},
+		{QuorumHTTPClientSettings{Policies: map[api.IRICommand]CommandPolicy{api.GetBalancesCmd: {Threshold: &half}}}, ErrInvalidQuorumThreshold},
+		{QuorumHTTPClientSettings{Policies: map[api.IRICommand]CommandPolicy{api.GetBalancesCmd: {NoResponseTolerance: &negative}}}, ErrInvalidNoResponseTolerance},
+		{QuorumHTTPClientSettings{Policies: map[api.IRICommand]CommandPolicy{api.GetBalancesCmd: {NoResponseTolerance: &tooHigh}}}, ErrInvalidNoResponseTolerance},
+		{QuorumHTTPClientSettings{Policies: map[api.IRICommand]CommandPolicy{api.BroadcastTransactionsCmd: {MinAcceptedShare: tooHigh}}}, ErrInvalidWriteQuorum},
+	}
+	for i, test := range tests {
+		test.settings.Nodes = []string{"a", "b"}
+		if _, err := NewQuorumHTTPClient(test.settings); errors.Cause(err) != test.err {
+			t.Errorf("test %d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+// fakeWriteTransport records the nodes which completed a write.
+type fakeWriteTransport struct {
+	fakeTransport
+	completed chan string
+}
+
+func (t *fakeWriteTransport) Do(ctx context.Context, node string, payload []byte) (int, []byte, error) {
+	status, data, err := t.fakeTransport.Do(ctx, node, payload)
+	if err == nil {
+		t.completed <- node
+	}
+	return status, data, err
+}
+
+func TestWriteQuorumCompletesOnAllNodes(t *testing.T) {
+	transport := &fakeWriteTransport{
+		fakeTransport: fakeTransport{
+			"a": {status: 200, body: `{"duration":1}`},
+			"b": {status: 200, body: `{"duration":2}`},
+			"c": {status: 400, body: `{"error":"invalid trytes"}`, delay: 30 * time.Millisecond},
+			"d": {status: 200, body: `{}`, delay: 30 * time.Millisecond},
+		},
+		completed: make(chan string, 4),
+	}
+	provider, err := NewQuorumHTTPClient(QuorumHTTPClientSettings{
+		Nodes:           transport.nodes(),
+		Transport:       transport,
+		ForceQuorumSend: map[api.IRICommand]struct{}{api.BroadcastTransactionsCmd: {}},
+		Policies:        map[api.IRICommand]CommandPolicy{api.BroadcastTransactionsCmd: {MinAccepted: 2}},
+	})
+	if err != nil {
+		t.Fatalf("unable to create provider: %v", err)
+	}
+	cmd := &api.BroadcastTransactionsCommand{Command: api.Command{Command: api.BroadcastTransactionsCmd}, Trytes: []string{"A"}}
+	report, err := provider.(QuorumProvider).SendWithReport(context.Background(), cmd, nil)
+	if err != nil {
+		t.Fatalf("expected the write to be accepted, got %v", err)
+	}
+	if !report.WriteQuorum || !report.EarlyTermination || len(report.Agreed()) != 2 {
+		t.Fatalf("expected the write to return once 2 nodes accepted it, got %+v", report)
+	}
+	if pending := report.Pending(); len(pending) != 2 || pending[0] != "c" || pending[1] != "d" {
+		t.Fatalf("expected c and d to be pending, got %v", pending)
+	}
+	if rejected := report.Rejected(); len(rejected) != 0 {
+		t.Fatalf("pending nodes must not be reported as rejected, got %v", rejected)
+	}
+
+	// the write still completes on the pending nodes
+	completed := map[string]bool{}
+	for len(completed) < 4 {
+		select {
+		case node := <-transport.completed:
+			completed[node] = true
+		case <-time.After(time.Second):
+			t.Fatalf("the write didn't complete on all nodes: %v", completed)
+		}
+	}
+}
+
+func TestWriteQuorumNotReached(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: `{}`},
+		"b": {status: 400, body: `{"error":"invalid trytes"}`},
+		"c": {err: errors.New("connection refused")},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		ForceQuorumSend: map[api.IRICommand]struct{}{api.BroadcastTransactionsCmd: {}},
+		Policies:        map[api.IRICommand]CommandPolicy{api.BroadcastTransactionsCmd: {MinAcceptedShare: 0.5}},
+	})
+	cmd := &api.BroadcastTransactionsCommand{Command: api.Command{Command: api.BroadcastTransactionsCmd}, Trytes: []string{"A"}}
+	report, err := provider.SendWithReport(context.Background(), cmd, nil)
+	if errors.Cause(err) != ErrWriteQuorumNotReached {
+		t.Fatalf("expected ErrWriteQuorumNotReached, got %v", err)
+	}
+	if rejected := report.Rejected(); len(rejected) != 2 {
+		t.Fatalf("expected b and c to be rejected, got %v", rejected)
+	}
+}
+
+func TestServerErrorsOpenTheCircuit(t *testing.T) {
+	transport := fakeTransport{
+		"a": {status: 200, body: balancesResponse},
+		"b": {status: 200, body: balancesResponse},
+		"c": {status: 502, body: `<html>Bad Gateway</html>`},
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{
+		Threshold:      0.6,
+		CircuitBreaker: &CircuitBreakerSettings{FailureThreshold: 2, OpenDuration: time.Minute},
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{}); err != nil {
+			t.Fatalf("call %d: expected the quorum to be reached, got %v", i, err)
+		}
+	}
+	for _, health := range provider.NodeHealth() {
+		if health.URL != "c" {
+			continue
+		}
+		if health.State != CircuitOpen || health.Successes != 0 || health.Failures != 2 {
+			t.Fatalf("expected the circuit of c to open after 2 server errors, got %+v", health)
+		}
+	}
+}
+
+func subtangleResponse(hash string, index int) fakeResponse {
+	return fakeResponse{status: 200, body: fmt.Sprintf(`{"latestSolidSubtangleMilestone":"%s","latestSolidSubtangleMilestoneIndex":%d,"duration":1}`, hash, index)}
+}
+
+func subtangleCommand() *api.GetLatestSolidSubtangleMilestoneCommand {
+	return &api.GetLatestSolidSubtangleMilestoneCommand{Command: api.Command{Command: api.GetNodeInfoCmd}}
+}
+
+func TestSubtangleStatsIncludeLaggingNodes(t *testing.T) {
+	transport := fakeTransport{
+		"a": subtangleResponse(milestoneHash, 100),
+		"b": subtangleResponse(milestoneHash, 100),
+		"c": subtangleResponse("LAGGING", 90),
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, ExcludeLaggingNodes: true, MinSyncedNodes: 2})
+	out := &api.GetLatestSolidSubtangleMilestoneResponse{}
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), out); err != nil {
+		t.Fatalf("expected the lagging node to be excluded, got %v", err)
+	}
+	if out.LatestSolidSubtangleMilestoneIndex != 100 {
+		t.Fatalf("expected index 100, got %d", out.LatestSolidSubtangleMilestoneIndex)
+	}
+	stats := provider.Stats()
+	if stats.SubtangleMilestoneDelta != 10 || stats.LaggingNodes != 1 || stats.SubtangleMilestoneDeltaViolations != 0 {
+		t.Fatalf("expected the delta to include the lagging node, got %+v", stats)
+	}
+}
+
+func nodeHealth(t *testing.T, provider QuorumProvider, node string) NodeHealth {
+	t.Helper()
+	for _, health := range provider.NodeHealth() {
+		if health.URL == node {
+			return health
+		}
+	}
+	t.Fatalf("no health for node %s", node)
+	return NodeHealth{}
+}
+
+func queried(report *QuorumReport, node string) bool {
+	for _, outcome := range report.Nodes {
+		if outcome.URL == node {
+			return true
+		}
+	}
+	return false
+}
+
+func TestForkDetectionQuarantinesMinority(t *testing.T) {
+	transport := fakeTransport{
+		"a": subtangleResponse(milestoneHash, 100),
+		"b": subtangleResponse(milestoneHash, 100),
+		"c": subtangleResponse(milestoneHash, 100),
+		"d": subtangleResponse("FORK", 100),
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, QuarantineDuration: time.Minute})
+	_, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{})
+	if errors.Cause(err) != ErrConflictingSubtangleMilestones {
+		t.Fatalf("expected ErrConflictingSubtangleMilestones, got %v", err)
+	}
+	if forks := provider.Stats().ForksDetected; forks != 1 {
+		t.Fatalf("expected 1 detected fork, got %d", forks)
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		if h := nodeHealth(t, provider, node); !h.QuarantinedUntil.IsZero() {
+			t.Fatalf("the majority must not be quarantined: %+v", h)
+		}
+	}
+	if h := nodeHealth(t, provider, "d"); !h.QuarantinedUntil.After(time.Now()) || len(h.Errors) != 1 {
+		t.Fatalf("expected d to be quarantined, got %+v", h)
+	}
+
+	// the quarantined node is no longer queried
+	out := &api.GetLatestSolidSubtangleMilestoneResponse{}
+	report, err := provider.SendWithReport(context.Background(), subtangleCommand(), out)
+	if err != nil {
+		t.Fatalf("expected the query to succeed without the quarantined node, got %v", err)
+	}
+	if queried(report, "d") || out.LatestSolidSubtangleMilestone != milestoneHash {
+		t.Fatalf("expected d to be excluded, got %+v", report.Nodes)
+	}
+}
+
+func TestForkDetectionWithWeights(t *testing.T) {
+	transport := fakeTransport{
+		"heavy": subtangleResponse("FORK", 100),
+		"b":     subtangleResponse(milestoneHash, 100),
+		"c":     subtangleResponse(milestoneHash, 100),
+	}
+
+	// the heavy node outweighs the other nodes, which are quarantined as the minority
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, NodeWeights: map[string]float64{"heavy": 3}})
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{}); errors.Cause(err) != ErrConflictingSubtangleMilestones {
+		t.Fatalf("expected ErrConflictingSubtangleMilestones, got %v", err)
+	}
+	for node, quarantined := range map[string]bool{"heavy": false, "b": true, "c": true} {
+		if h := nodeHealth(t, provider, node); h.QuarantinedUntil.IsZero() == quarantined {
+			t.Errorf("%s: expected quarantined to be %v, got %+v", node, quarantined, h)
+		}
+	}
+
+	// without a strict majority it can't be determined which nodes are on the fork
+	provider = newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, NodeWeights: map[string]float64{"heavy": 2}})
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{}); errors.Cause(err) != ErrConflictingSubtangleMilestones {
+		t.Fatalf("expected ErrConflictingSubtangleMilestones, got %v", err)
+	}
+	for _, node := range transport.nodes() {
+		if h := nodeHealth(t, provider, node); !h.QuarantinedUntil.IsZero() {
+			t.Errorf("no node must be quarantined on a tie, got %+v", h)
+		}
+	}
+}
+
+func TestLaggingNodesAreExcludedAndReadmitted(t *testing.T) {
+	transport := fakeTransport{
+		"a": subtangleResponse(milestoneHash, 100),
+		"b": subtangleResponse(milestoneHash, 100),
+		"c": subtangleResponse("LAGGING", 90),
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, ExcludeLaggingNodes: true})
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{}); err != nil {
+		t.Fatalf("expected the lagging node to be excluded, got %v", err)
+	}
+	if h := nodeHealth(t, provider, "c"); !h.Lagging || h.SubtangleMilestoneIndex != 90 {
+		t.Fatalf("expected c to be lagging, got %+v", h)
+	}
+
+	// lagging nodes are excluded from other calls
+	report, err := provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if queried(report, "c") {
+		t.Fatalf("the lagging node must not be queried, got %+v", report.Nodes)
+	}
+
+	// once caught up the node is used again
+	transport["c"] = subtangleResponse(milestoneHash, 100)
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{}); err != nil {
+		t.Fatalf("expected the query to succeed, got %v", err)
+	}
+	if h := nodeHealth(t, provider, "c"); h.Lagging || h.SubtangleMilestoneIndex != 100 {
+		t.Fatalf("expected c to be synced, got %+v", h)
+	}
+	report, err = provider.SendWithReport(context.Background(), balancesCommand(), &api.GetBalancesResponse{})
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if !queried(report, "c") {
+		t.Fatalf("the caught up node must be queried, got %+v", report.Nodes)
+	}
+	if excluded := provider.Stats().LaggingNodesExcluded; excluded != 1 {
+		t.Fatalf("expected 1 excluded node, got %d", excluded)
+	}
+}
+
+func TestMinSyncedNodes(t *testing.T) {
+	transport := fakeTransport{
+		"a": subtangleResponse(milestoneHash, 100),
+		"b": subtangleResponse("LAGGING", 90),
+		"c": subtangleResponse("LAGGING", 90),
+	}
+	provider := newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, ExcludeLaggingNodes: true})
+	_, err := provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{})
+	if errors.Cause(err) != ErrNotEnoughSyncedNodes {
+		t.Fatalf("expected ErrNotEnoughSyncedNodes with the default of 2 synced nodes, got %v", err)
+	}
+
+	provider = newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1, ExcludeLaggingNodes: true, MinSyncedNodes: 1})
+	out := &api.GetLatestSolidSubtangleMilestoneResponse{}
+	if _, err := provider.SendWithReport(context.Background(), subtangleCommand(), out); err != nil {
+		t.Fatalf("expected a single synced node to suffice, got %v", err)
+	}
+	if out.LatestSolidSubtangleMilestoneIndex != 100 {
+		t.Fatalf("expected index 100, got %d", out.LatestSolidSubtangleMilestoneIndex)
+	}
+
+	// without excluding lagging nodes the delta fails the query
+	provider = newTestProvider(t, transport, QuorumHTTPClientSettings{MaxSubtangleMilestoneDelta: 1})
+	_, err = provider.SendWithReport(context.Background(), subtangleCommand(), &api.GetLatestSolidSubtangleMilestoneResponse{})
+	if errors.Cause(err) != ErrExceededMaxSubtangleMilestoneDelta {
+		t.Fatalf("expected ErrExceededMaxSubtangleMilestoneDelta, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package quorum
+
+import (
+	"github.com/iotaledger/iota.go/api"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// QuorumReport describes how the result of a call was decided.
+type QuorumReport struct {
+	// The IRI command which was executed.
+	Command api.IRICommand
+	// When the call was started and how long it took.
+	Time     time.Time
+	Duration time.Duration
+	// Whether the call was executed in quorum. Calls which aren't executed in quorum
+	// are sent to the primary or a random node, which is the only entry in Nodes.
+	Quorum bool
+	// The outcome of the call on each node, including the nodes which didn't respond yet.
+	Nodes []NodeOutcome
+	// The groups of equal responses, ordered by their votes descending.
+	Groups []VoteGroup
+	// The index of the group whose response was returned, -1 if no group reached the threshold.
+	Selected int
+	// The share of the votes of the group with the most votes.
+	Percentage float64
+	// Whether the call returned before all nodes responded as the quorum was already decided.
+	EarlyTermination bool
+	// Whether the call was decided per element.
+	ElementQuorum bool
+	// Whether the call was a write which only needed to be accepted by enough nodes.
+	// The groups of a write are formed by the status codes of the responses.
+	WriteQuorum bool
+	// Whether the defaults were injected into the result, either for the entire
+	// result or for single elements of a call decided per element.
+	DefaultsInjected bool
+	// The error the call failed with, if any.
+	Err error
+}
+
+// Agreed returns the URLs of the nodes whose response was returned.
+func (r *QuorumReport) Agreed() []string {
+	if r.Selected == -1 || r.Selected >= len(r.Groups) {
+		return nil
+	}
+	return r.Groups[r.Selected].Nodes
+}
+
+// Dissented returns the URLs of the nodes which voted for a response which wasn't returned.
+func (r *QuorumReport) Dissented() []string {
+	var dissented []string
+	for _, node := range r.Nodes {
+		if node.Group != -1 && node.Group != r.Selected {
+			dissented = append(dissented, node.URL)
+		}
+	}
+	return dissented
+}
+
+// Rejected returns the URLs of the nodes which failed to give a response or responded with an error.
+func (r *QuorumReport) Rejected() []string {
+	var rejected []string
+	for _, node := range r.Nodes {
+		if !node.Pending && (node.Err != nil || node.StatusCode != http.StatusOK) {
+			rejected = append(rejected, node.URL)
+		}
+	}
+	return rejected
+}
+
+// Pending returns the URLs of the nodes which didn't respond yet when the call returned.
+// Writes still complete on these nodes, the requests to them are cancelled otherwise.
+func (r *QuorumReport) Pending() []string {
+	var pending []string
+	for _, node := range r.Nodes {
+		if node.Pending {
+			pending = append(pending, node.URL)
+		}
+	}
+	return pending
+}
+
+// ReportLog keeps the most recent reports of quorum calls.
+type ReportLog struct {
+	mu      sync.Mutex
+	reports []QuorumReport
+	next    int
+	full    bool
+}
+
+// NewReportLog creates a new ReportLog keeping the given amount of reports.
+func NewReportLog(size int) *ReportLog {
+	if size <= 0 {
+		size = DefaultReportLogSize
+	}
+	return &ReportLog{reports: make([]QuorumReport, size)}
+}
+
+// DefaultReportLogSize is the amount of reports kept by a ReportLog if no size is given.
+const DefaultReportLogSize = 100
+
+// Add adds the given report to the log, replacing the oldest report if the log is full.
+func (l *ReportLog) Add(report QuorumReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reports[l.next] = report
+	l.next = (l.next + 1) % len(l.reports)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Reports returns up to limit of the most recent reports, newest first.
+// All kept reports are returned if limit is <= 0.
+func (l *ReportLog) Reports(limit int) []QuorumReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.reports)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	reports := make([]QuorumReport, count)
+	for i := range reports {
+		reports[i] = l.reports[(l.next-1-i+len(l.reports))%len(l.reports)]
+	}
+	return reports
+}
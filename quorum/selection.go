@@ -0,0 +1,56 @@
+package quorum
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// SelectionStrategy defines how a node is selected from Nodes
+// for the commands for which no quorum can be done.
+type SelectionStrategy string
+
+// selection strategies
+const (
+	// selects a random node.
+	SelectRandom SelectionStrategy = "random"
+	// selects the nodes one after another.
+	SelectRoundRobin SelectionStrategy = "round-robin"
+	// selects the node with the lowest moving average latency.
+	// nodes without any measured latency are selected first.
+	SelectLowestLatency SelectionStrategy = "lowest-latency"
+	// selects the node with the fewest requests in flight.
+	SelectLeastInFlight SelectionStrategy = "least-in-flight"
+)
+
+// returns the indices of the nodes in Nodes in the order
+// in which they should be used according to the selection strategy.
+func (hc *quorumhttpclient) selectionOrder() []int {
+	switch hc.settings.Selection {
+	case SelectRoundRobin:
+		start := int(atomic.AddUint64(&hc.roundRobin, 1)-1) % hc.nodesCount
+		order := make([]int, hc.nodesCount)
+		for i := range order {
+			order[i] = (start + i) % hc.nodesCount
+		}
+		return order
+	case SelectLowestLatency, SelectLeastInFlight:
+		// shuffle first, so that equal nodes are selected evenly
+		order := rand.Perm(hc.nodesCount)
+		load := make([]float64, hc.nodesCount)
+		for i, node := range hc.settings.Nodes {
+			avgLatency, inFlight := hc.tracker.load(node)
+			if hc.settings.Selection == SelectLowestLatency {
+				load[i] = float64(avgLatency)
+			} else {
+				load[i] = float64(inFlight)
+			}
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return load[order[i]] < load[order[j]]
+		})
+		return order
+	default:
+		return rand.Perm(hc.nodesCount)
+	}
+}
@@ -0,0 +1,72 @@
+package quorum
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/iotaledger/iota.go/api"
+	"io/ioutil"
+	"net/http"
+)
+
+// NodeTransport executes serialized IRI API commands on a node.
+// Implementations must be safe for concurrent use.
+type NodeTransport interface {
+	// Do sends the given JSON payload to the given node and returns
+	// the status code and the body of the node's response.
+	Do(ctx context.Context, node string, payload []byte) (int, []byte, error)
+}
+
+// HTTPTransport is the default NodeTransport which executes commands via HTTP POST requests.
+type HTTPTransport struct {
+	// The underlying HTTPClient to use. Defaults to http.DefaultClient.
+	// Use a custom client to configure TLS client certificates or proxies.
+	Client api.HTTPClient
+	// Headers which are added to the requests to every node.
+	Headers http.Header
+	// Headers which are added to the requests to specific nodes, keyed by the node's URL.
+	// They override Headers with the same name, i.e. to use per node credentials.
+	NodeHeaders map[string]http.Header
+}
+
+// Do sends the given JSON payload to the given node via an HTTP POST request.
+func (t *HTTPTransport) Do(ctx context.Context, node string, payload []byte) (int, []byte, error) {
+	req, err := http.NewRequest("POST", node, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-IOTA-API-Version", "1")
+	for name, values := range t.Headers {
+		req.Header[name] = values
+	}
+	for name, values := range t.NodeHeaders[node] {
+		req.Header[name] = values
+	}
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// BasicAuthHeader returns the headers to authenticate with the given username and password.
+func BasicAuthHeader(username string, password string) http.Header {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return http.Header{"Authorization": {"Basic " + credentials}}
+}
+
+// BearerTokenHeader returns the headers to authenticate with the given bearer token.
+func BearerTokenHeader(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
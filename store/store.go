@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/iotaledger/iota.go/trinary"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RecordKind defines what kind of measurement event a Record describes.
+type RecordKind string
+
+// record kinds
+const (
+	RecordSent      RecordKind = "sent"
+	RecordConfirmed RecordKind = "confirmed"
+)
+
+// Record is a single measurement event persisted by the Store.
+type Record struct {
+	Kind RecordKind   `json:"kind"`
+	Hash trinary.Hash `json:"hash"`
+	Time time.Time    `json:"time"`
+}
+
+// Store is an append-only file which persists measurement events,
+// one JSON encoded Record per line.
+type Store struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// New opens the Store at the given path. The file is created if it doesn't exist yet.
+func New(path string) (*Store, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "unable to create store directory %s", dir)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open store file %s", path)
+	}
+	return &Store{path: path, file: file}, nil
+}
+
+// Load reads all records from the Store in the order they were appended.
+// A trailing partially written record (i.e. from a crash) is ignored.
+func (s *Store) Load() ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open store file %s", s.path)
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rec := Record{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read store file")
+	}
+	return records, nil
+}
+
+// Append persists the given record.
+func (s *Store) Append(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "unable to append record")
+	}
+	return s.file.Sync()
+}
+
+// Compact atomically replaces the content of the Store with the given records.
+func (s *Store) Compact(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to create compaction file")
+	}
+	w := bufio.NewWriter(tmp)
+	for _, rec := range records {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write compaction file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return errors.Wrap(err, "unable to replace store file")
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to reopen store file %s", s.path)
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+// Close closes the underlying file of the Store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
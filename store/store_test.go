@@ -0,0 +1,114 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data", "store.jsonl")
+	st, err := New(path)
+	if err != nil {
+		t.Fatalf("unable to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st, path
+}
+
+func appendRecords(t *testing.T, st *Store, records ...Record) {
+	t.Helper()
+	for _, rec := range records {
+		if err := st.Append(rec); err != nil {
+			t.Fatalf("unable to append record: %v", err)
+		}
+	}
+}
+
+func expectRecords(t *testing.T, st *Store, expected ...Record) {
+	t.Helper()
+	records, err := st.Load()
+	if err != nil {
+		t.Fatalf("unable to load records: %v", err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d: %+v", len(expected), len(records), records)
+	}
+	for i, rec := range records {
+		if rec.Kind != expected[i].Kind || rec.Hash != expected[i].Hash || !rec.Time.Equal(expected[i].Time) {
+			t.Errorf("record %d: expected %+v, got %+v", i, expected[i], rec)
+		}
+	}
+}
+
+func TestAppendAndLoad(t *testing.T) {
+	st, _ := newTestStore(t)
+	expectRecords(t, st)
+
+	ts := time.Unix(1000, 0)
+	records := []Record{
+		{Kind: RecordSent, Hash: "A", Time: ts},
+		{Kind: RecordSent, Hash: "B", Time: ts.Add(time.Second)},
+		{Kind: RecordConfirmed, Hash: "A", Time: ts.Add(time.Minute)},
+	}
+	appendRecords(t, st, records...)
+	expectRecords(t, st, records...)
+
+	// reopening the store keeps the persisted records
+	if err := st.Close(); err != nil {
+		t.Fatalf("unable to close store: %v", err)
+	}
+	reopened, err := New(st.path)
+	if err != nil {
+		t.Fatalf("unable to reopen store: %v", err)
+	}
+	defer reopened.Close()
+	expectRecords(t, reopened, records...)
+}
+
+func TestLoadSkipsTruncatedRecord(t *testing.T) {
+	st, path := newTestStore(t)
+	rec := Record{Kind: RecordSent, Hash: "A", Time: time.Unix(1000, 0)}
+	appendRecords(t, st, rec)
+
+	// a crash while appending leaves a partially written line behind
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("unable to open store file: %v", err)
+	}
+	if _, err := file.WriteString(`{"kind":"sent","hash":"B","ti`); err != nil {
+		t.Fatalf("unable to write truncated record: %v", err)
+	}
+	file.Close()
+
+	expectRecords(t, st, rec)
+}
+
+func TestCompact(t *testing.T) {
+	st, path := newTestStore(t)
+	ts := time.Unix(1000, 0)
+	appendRecords(t, st,
+		Record{Kind: RecordSent, Hash: "A", Time: ts},
+		Record{Kind: RecordSent, Hash: "B", Time: ts.Add(time.Second)},
+		Record{Kind: RecordConfirmed, Hash: "B", Time: ts.Add(time.Minute)},
+	)
+
+	kept := []Record{
+		{Kind: RecordSent, Hash: "B", Time: ts.Add(time.Second)},
+		{Kind: RecordConfirmed, Hash: "B", Time: ts.Add(time.Minute)},
+	}
+	if err := st.Compact(kept); err != nil {
+		t.Fatalf("unable to compact store: %v", err)
+	}
+	expectRecords(t, st, kept...)
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the compaction file to be gone, got %v", err)
+	}
+
+	// appends go to the compacted file
+	added := Record{Kind: RecordSent, Hash: "C", Time: ts.Add(2 * time.Minute)}
+	appendRecords(t, st, added)
+	expectRecords(t, st, append(kept, added)...)
+}
@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// ErrUnknownEventType is returned when subscribing to an event type which doesn't exist.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// EventType defines the kind of an Event.
+type EventType string
+
+// event types published by ConfBox
+const (
+	// a new result is available because a point closed or a transaction confirmed.
+	EventResult EventType = "result"
+	// a probe transaction was sent off.
+	EventSent EventType = "sent"
+	// a probe transaction got confirmed.
+	EventConfirmed EventType = "confirmed"
+)
+
+// EventTypes are all event types which can be subscribed to.
+var EventTypes = []EventType{EventResult, EventSent, EventConfirmed}
+
+// Event is a single message published to subscribers.
+type Event struct {
+	Type EventType
+	Data interface{}
+}
+
+// subscriberBufferSize defines how many events are buffered for a subscriber
+// before further events are dropped for it.
+const subscriberBufferSize = 32
+
+// Subscriber receives the published events of the types it subscribed to on C.
+type Subscriber struct {
+	C     chan Event
+	types map[EventType]struct{}
+}
+
+// Broker fans out published events to its subscribers.
+// Events are dropped for subscribers which don't keep up.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+}
+
+// NewBroker creates a new Broker.
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[*Subscriber]struct{}{}}
+}
+
+// ParseEventType parses the given event type name.
+func ParseEventType(name string) (EventType, error) {
+	for _, t := range EventTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", errors.Wrapf(ErrUnknownEventType, "%s", name)
+}
+
+// Subscribe creates a new Subscriber receiving events of the given types.
+// If no types are given, the Subscriber receives all events.
+func (b *Broker) Subscribe(types ...EventType) *Subscriber {
+	if len(types) == 0 {
+		types = EventTypes
+	}
+	sub := &Subscriber{C: make(chan Event, subscriberBufferSize), types: map[EventType]struct{}{}}
+	for _, t := range types {
+		sub.types[t] = struct{}{}
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes the given Subscriber from the Broker.
+func (b *Broker) Unsubscribe(sub *Subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// Publish sends the given event to all subscribers of its type.
+func (b *Broker) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if _, ok := sub.types[e.Type]; !ok {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+		}
+	}
+}
+
+// HasSubscribers tells whether any subscriber is registered on the Broker.
+func (b *Broker) HasSubscribers() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers) > 0
+}
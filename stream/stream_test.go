@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+// returns the events buffered for the given subscriber without blocking.
+func drain(sub *Subscriber) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-sub.C:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPublishFiltersByType(t *testing.T) {
+	b := NewBroker()
+	if b.HasSubscribers() {
+		t.Fatal("a new broker must not have subscribers")
+	}
+	all := b.Subscribe()
+	sent := b.Subscribe(EventSent)
+	results := b.Subscribe(EventResult, EventConfirmed)
+
+	b.Publish(Event{Type: EventSent, Data: "A"})
+	b.Publish(Event{Type: EventConfirmed, Data: "A"})
+	b.Publish(Event{Type: EventResult, Data: 0.5})
+
+	if events := drain(all); len(events) != 3 {
+		t.Fatalf("expected all 3 events, got %v", events)
+	}
+	if events := drain(sent); len(events) != 1 || events[0].Type != EventSent || events[0].Data != "A" {
+		t.Fatalf("expected only the sent event, got %v", events)
+	}
+	if events := drain(results); len(events) != 2 || events[0].Type != EventConfirmed || events[1].Type != EventResult {
+		t.Fatalf("expected the confirmed and result events in order, got %v", events)
+	}
+
+	b.Unsubscribe(all)
+	b.Unsubscribe(sent)
+	b.Unsubscribe(results)
+	b.Publish(Event{Type: EventSent})
+	if b.HasSubscribers() || len(drain(all)) != 0 {
+		t.Fatal("unsubscribed subscribers must not receive events")
+	}
+}
+
+func TestPublishDropsEventsForSlowSubscribers(t *testing.T) {
+	b := NewBroker()
+	slow := b.Subscribe()
+	fast := b.Subscribe()
+
+	var received int
+	for i := 0; i < subscriberBufferSize+10; i++ {
+		b.Publish(Event{Type: EventSent, Data: i})
+		received += len(drain(fast))
+	}
+	if received != subscriberBufferSize+10 {
+		t.Fatalf("expected the fast subscriber to receive all events, got %d", received)
+	}
+
+	// the slow subscriber keeps the events which fit into its buffer
+	events := drain(slow)
+	if len(events) != subscriberBufferSize {
+		t.Fatalf("expected %d buffered events, got %d", subscriberBufferSize, len(events))
+	}
+	if first, last := events[0].Data.(int), events[len(events)-1].Data.(int); first != 0 || last != subscriberBufferSize-1 {
+		t.Fatalf("expected the oldest events to be kept, got %d to %d", first, last)
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	for _, et := range EventTypes {
+		if parsed, err := ParseEventType(string(et)); err != nil || parsed != et {
+			t.Errorf("expected %s to be parsed, got %s (%v)", et, parsed, err)
+		}
+	}
+	if _, err := ParseEventType("milestone"); errors.Cause(err) != ErrUnknownEventType {
+		t.Fatalf("expected ErrUnknownEventType, got %v", err)
+	}
+}